@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/justbuchanan/stylize/stylize"
+)
+
+// Implements `stylize apply`.
+func applyCommand(args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: stylize apply [flags] <patch file>")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Applies a patch generated with --patch_output. Pass '-' to read the patch from stdin.")
+		fmt.Fprintln(os.Stderr, "Hunks that no longer match the files are skipped.")
+		fmt.Fprintln(os.Stderr, "")
+		fs.PrintDefaults()
+	}
+	dir := fs.String("dir", "", "Directory that the paths in the patch are relative to. Defaults to the directory of the outermost .stylize.yml, the same as the root used when the patch was generated, or the current directory if there isn't one.")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	var in io.Reader = os.Stdin
+	if fs.Arg(0) != "-" {
+		patchFile, err := os.Open(fs.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer patchFile.Close()
+		in = patchFile
+	}
+
+	patches, err := stylize.ParsePatch(in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rootDir, err := filepath.Abs(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(*dir) == 0 {
+		if configFile := stylize.FindConfig(rootDir); len(configFile) > 0 {
+			rootDir = filepath.Dir(configFile)
+		}
+	}
+
+	failed := false
+	for _, r := range stylize.ApplyPatch(rootDir, patches) {
+		if r.Error != nil {
+			log.Printf("Error patching %s: %v", r.Path, r.Error)
+			failed = true
+			continue
+		}
+		for _, header := range r.Skipped {
+			log.Printf("Skipped hunk in %s that no longer matches: %s", r.Path, header)
+			failed = true
+		}
+		if r.Applied > 0 {
+			log.Printf("Patched %s", r.Path)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/justbuchanan/stylize/stylize"
+)
+
+func openCache() (*stylize.ResultCache, error) {
+	dir, err := stylize.DefaultCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return stylize.NewResultCache(dir)
+}
+
+// Implements `stylize cache <subcommand>`.
+func cacheCommand(args []string) {
+	if len(args) != 1 || args[0] != "clean" {
+		fmt.Fprintln(os.Stderr, "Usage: stylize cache clean")
+		os.Exit(1)
+	}
+
+	cache, err := openCache()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = cache.Clean(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Removed cache directory %s", cache.Dir)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/justbuchanan/stylize/stylize"
+)
+
+func configUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: stylize config show [flags]")
+	fmt.Fprintln(os.Stderr, "       stylize config validate [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "show prints the config after merging the files it extends, with the file that each setting came from.")
+	fmt.Fprintln(os.Stderr, "validate checks the config and any nested configs under its directory without formatting anything.")
+}
+
+// Implements `stylize config <subcommand>`.
+func configCommand(args []string) {
+	if len(args) == 0 || (args[0] != "show" && args[0] != "validate") {
+		configUsage()
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("config "+args[0], flag.ExitOnError)
+	fs.Usage = func() {
+		configUsage()
+		fmt.Fprintln(os.Stderr, "")
+		fs.PrintDefaults()
+	}
+	f := &contextFlags{}
+	fs.StringVar(&f.configFile, "config", "", "Config file. Defaults to the outermost .stylize.yml in --dir or its parents, up to the top of the git repo, the same as when formatting.")
+	fs.StringVar(&f.dir, "dir", ".", "Directory to look for the config from.")
+	fs.Parse(args[1:])
+
+	configFile, rootDir, _, err := f.findConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if args[0] == "validate" {
+		if !validateConfigs(configFile, rootDir) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(configFile) == 0 {
+		log.Fatal("No config file found")
+	}
+	cfg, err := stylize.LoadConfig(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("# Resolved config from %s\n", configFile)
+	if err = cfg.WriteAnnotated(os.Stdout, filepath.Dir(configFile)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Prints the problems with a config. Returns false if there were any errors.
+func reportConfigProblems(file string, problems []*stylize.ConfigProblem) bool {
+	ok := true
+	for _, p := range problems {
+		if p.Warning {
+			fmt.Printf("warning: %v\n", p)
+		} else {
+			fmt.Printf("error: %v\n", p)
+			ok = false
+		}
+	}
+	if len(problems) == 0 {
+		fmt.Printf("%s: ok\n", file)
+	}
+	return ok
+}
+
+// Checks the main config and the nested configs under rootDir. Custom
+// formatters and plugins from the main config are registered so that they can
+// be referred to. Returns false if any of the configs have errors.
+func validateConfigs(configFile, rootDir string) bool {
+	ok := true
+	var exclude []string
+	if len(configFile) > 0 {
+		cfg, err := stylize.LoadConfig(configFile)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			return false
+		}
+		if err = cfg.RegisterCustomFormatters(); err != nil {
+			fmt.Printf("error: %s: %v\n", configFile, err)
+			return false
+		}
+		// plugins that started before a failure are shut down too
+		defer stylize.ShutdownPlugins()
+		if err = cfg.RegisterPlugins(); err != nil {
+			fmt.Printf("error: %s: %v\n", configFile, err)
+			return false
+		}
+		ok = reportConfigProblems(configFile, cfg.Validate(false))
+		exclude = cfg.ExcludePatterns
+	}
+
+	absConfig, _ := filepath.Abs(configFile)
+	excluder := stylize.NewExcluder(rootDir, append([]string{".git", ".hg"}, exclude...), []string{stylize.StylizeIgnoreFile})
+	found := len(configFile) > 0
+	for file := range stylize.IterateAllFiles(rootDir, excluder) {
+		absFile := filepath.Join(rootDir, file)
+		if filepath.Base(file) != stylize.ConfigFileName || absFile == absConfig {
+			continue
+		}
+		found = true
+		cfg, err := stylize.LoadConfig(absFile)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			ok = false
+			continue
+		}
+		if !reportConfigProblems(absFile, cfg.Validate(true)) {
+			ok = false
+		}
+	}
+
+	if !found {
+		fmt.Println("No config files found")
+	}
+	return ok
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/justbuchanan/stylize/stylize"
+)
+
+// Implements `stylize init`.
+func initCommand(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: stylize init [flags]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Writes a starter .stylize.yml based on the files in the directory, the installed")
+		fmt.Fprintln(os.Stderr, "formatters, and style files such as .clang-format that are already present.")
+		fmt.Fprintln(os.Stderr, "")
+		fs.PrintDefaults()
+	}
+	dir := fs.String("dir", ".", "Directory to examine and write the config to.")
+	exclude := fs.String("exclude", "", "A list of exclude patterns (comma-separated) for files that shouldn't be examined.")
+	force := fs.Bool("force", false, "Overwrite an existing config.")
+	stdout := fs.Bool("stdout", false, "Print the config instead of writing it.")
+	fs.Parse(args)
+
+	rootDir, err := filepath.Abs(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	configFile := filepath.Join(rootDir, stylize.ConfigFileName)
+	if _, err := os.Stat(configFile); err == nil && !*stdout && !*force {
+		log.Fatalf("%s already exists. Pass --force to overwrite it.", configFile)
+	}
+
+	patterns := []string{".git", ".hg"}
+	if len(*exclude) > 0 {
+		patterns = append(patterns, strings.Split(*exclude, ",")...)
+	}
+	excluder := stylize.NewExcluder(rootDir, patterns, []string{stylize.StylizeIgnoreFile, ".gitignore"})
+	survey, err := stylize.SurveyRepo(rootDir, excluder)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *stdout {
+		if err = survey.WriteConfig(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	out, err := os.Create(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = survey.WriteConfig(out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Wrote %s", configFile)
+}
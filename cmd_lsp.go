@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/justbuchanan/stylize/stylize"
+)
+
+// Implements `stylize lsp`.
+func lspCommand(args []string) {
+	fs := flag.NewFlagSet("lsp", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: stylize lsp [flags]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Runs a language server on stdin/stdout that provides document formatting to editors.")
+		fmt.Fprintln(os.Stderr, "")
+		fs.PrintDefaults()
+	}
+	ctxFlags := addContextFlags(fs)
+	fs.Parse(args)
+
+	ctx, err := ctxFlags.loadContext()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = stylize.ServeLSP(ctx, os.Stdin, os.Stdout)
+	stylize.ShutdownPlugins()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
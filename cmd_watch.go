@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/justbuchanan/stylize/stylize"
+)
+
+// Implements `stylize watch`.
+func watchCommand(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: stylize watch [flags]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Keeps running and checks files as they change. With -i, formats them in-place.")
+		fmt.Fprintln(os.Stderr, "")
+		fs.PrintDefaults()
+	}
+	ctxFlags := addContextFlags(fs)
+	pollFlag := fs.Duration("poll_interval", stylize.DefaultPollInterval, "How often to check whether changed files are ready to be checked.")
+	debounceFlag := fs.Duration("debounce", stylize.DefaultDebounce, "How long a file must be unchanged before it's checked.")
+	fs.Parse(args)
+
+	ctx, err := ctxFlags.loadContext()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	watcher := stylize.Watcher{
+		PollInterval: *pollFlag,
+		Debounce:     *debounceFlag,
+		Reload:       ctxFlags.loadContext,
+	}
+
+	// stop cleanly on ctrl-c
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	err = watcher.Run(ctx, stop)
+	stylize.ShutdownPlugins()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -3,6 +3,7 @@ package formatters
 import (
 	"io"
 	"os/exec"
+	"strings"
 )
 
 // https://github.com/ambv/black
@@ -21,10 +22,42 @@ func (F *BlackFormatter) IsInstalled() bool {
 	return err == nil
 }
 
+func (F *BlackFormatter) Version() (string, error) {
+	return commandVersion("black", "--version")
+}
+
+func (F *BlackFormatter) StyleConfigFiles() []string {
+	return []string{"pyproject.toml"}
+}
+
 func (F *BlackFormatter) FormatToBuffer(args []string, file string, in io.Reader, out io.Writer) error {
-	return runIOCommand(append(append([]string{"black"}, args...), "-"), in, out)
+	// Without the file's path, black looks for pyproject.toml starting from
+	// the working directory rather than from the file like it does in
+	// ListUnformatted().
+	cmd := []string{"black"}
+	if len(file) > 0 {
+		cmd = append(cmd, "--stdin-filename", file)
+	}
+	return runIOCommand(append(append(cmd, args...), "-"), in, out)
 }
 
 func (F *BlackFormatter) FormatInPlace(args []string, file string) error {
 	return runIOCommand(append([]string{"black", file}, args...), nil, nil)
 }
+
+func (F *BlackFormatter) ListUnformatted(args []string, files []string) ([]string, error) {
+	// black exits with status 1 if any files would be reformatted
+	cmd := append(append([]string{"black", "--check"}, args...), files...)
+	_, stderr, err := runBatchCommand(cmd, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var listed []string
+	for _, line := range outputLines(stderr) {
+		if strings.HasPrefix(line, "would reformat ") {
+			listed = append(listed, strings.TrimPrefix(line, "would reformat "))
+		}
+	}
+	return matchListedFiles(files, listed)
+}
@@ -0,0 +1,197 @@
+package formatters
+
+// Runs black as a worker using blackd, which formats code sent to it over HTTP.
+// See https://black.readthedocs.io/en/stable/usage_and_configuration/black_as_a_server.html
+//
+// Unlike black itself, blackd doesn't read settings from pyproject.toml. Files
+// that have black settings in a pyproject.toml are formatted with black's
+// regular command instead, so that the settings are applied.
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type blackdWorker struct {
+	cmd *exec.Cmd
+	url string
+}
+
+func (F *BlackFormatter) StartWorker() (Worker, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("blackd", "--bind-host", "127.0.0.1", "--bind-port", fmt.Sprint(port))
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	if err = waitForPort(cmd, port); err != nil {
+		return nil, err
+	}
+	return &blackdWorker{cmd: cmd, url: fmt.Sprintf("http://127.0.0.1:%d/", port)}, nil
+}
+
+// Converts black's command line arguments to the equivalent blackd headers.
+// Returns ErrWorkerUnsupported for arguments that blackd doesn't support.
+func blackdHeaders(args []string, file string) (http.Header, error) {
+	headers := make(http.Header)
+	var targetVersions []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := ""
+		if eq := strings.IndexByte(arg, '='); eq >= 0 && strings.HasPrefix(arg, "--") {
+			arg, value = arg[:eq], arg[eq+1:]
+		}
+		// returns the value of an argument that takes one. A missing value
+		// is left for black itself to report.
+		takeValue := func() (string, error) {
+			if len(value) > 0 {
+				return value, nil
+			}
+			if i+1 >= len(args) {
+				return "", ErrWorkerUnsupported
+			}
+			i++
+			return args[i], nil
+		}
+
+		switch arg {
+		case "-l", "--line-length":
+			v, err := takeValue()
+			if err != nil {
+				return nil, err
+			}
+			headers.Set("X-Line-Length", v)
+		case "-t", "--target-version":
+			v, err := takeValue()
+			if err != nil {
+				return nil, err
+			}
+			targetVersions = append(targetVersions, v)
+		case "-S", "--skip-string-normalization":
+			headers.Set("X-Skip-String-Normalization", "1")
+		case "-C", "--skip-magic-trailing-comma":
+			headers.Set("X-Skip-Magic-Trailing-Comma", "1")
+		case "--fast":
+			headers.Set("X-Fast-Or-Safe", "fast")
+		case "--safe":
+			headers.Set("X-Fast-Or-Safe", "safe")
+		case "--preview":
+			headers.Set("X-Preview", "1")
+		case "-q", "--quiet":
+		default:
+			return nil, ErrWorkerUnsupported
+		}
+	}
+
+	if strings.HasSuffix(file, ".pyi") {
+		headers.Set("X-Python-Variant", "pyi")
+	} else if len(targetVersions) > 0 {
+		headers.Set("X-Python-Variant", strings.Join(targetVersions, ","))
+	}
+	return headers, nil
+}
+
+// Returns true if the TOML content has a [tool.black] table.
+func hasBlackSection(content []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "[tool.black]" || strings.HasPrefix(line, "[tool.black.") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if black would read settings from a config file when formatting
+// the file. Like black, this uses the pyproject.toml at the root of the file's
+// project, which is the nearest directory containing .git, .hg, or
+// pyproject.toml. Without one, black falls back to the user-level config.
+func hasBlackSettings(file string) bool {
+	absPath, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+	for dir := filepath.Dir(absPath); ; {
+		if content, err := ioutil.ReadFile(filepath.Join(dir, "pyproject.toml")); err == nil {
+			return hasBlackSection(content)
+		}
+		if fileExists(filepath.Join(dir, ".git")) || fileExists(filepath.Join(dir, ".hg")) {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if len(configDir) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return false
+		}
+		configDir = filepath.Join(home, ".config")
+	}
+	content, err := ioutil.ReadFile(filepath.Join(configDir, "black"))
+	return err == nil && hasBlackSection(content)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (W *blackdWorker) Format(args []string, file string, content []byte) ([]byte, error) {
+	if hasBlackSettings(file) {
+		return nil, ErrWorkerUnsupported
+	}
+	headers, err := blackdHeaders(args, file)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", W.url, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = headers
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(ErrWorkerCrashed, err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(ErrWorkerCrashed, err.Error())
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return body, nil
+	case http.StatusNoContent:
+		// already formatted
+		return content, nil
+	default:
+		return nil, errors.Errorf("blackd: %s", strings.TrimSpace(string(body)))
+	}
+}
+
+func (W *blackdWorker) Close() error {
+	W.cmd.Process.Kill()
+	// the process was already waited on by waitForPort()
+	return nil
+}
@@ -20,6 +20,14 @@ func (F *BuildifierFormatter) IsInstalled() bool {
 	return err == nil
 }
 
+func (F *BuildifierFormatter) Version() (string, error) {
+	return commandVersion("buildifier", "--version")
+}
+
+func (F *BuildifierFormatter) StyleConfigFiles() []string {
+	return []string{".buildifier.json"}
+}
+
 func (F *BuildifierFormatter) FormatToBuffer(args []string, file string, in io.Reader, out io.Writer) error {
 	return runIOCommand([]string{"buildifier"}, in, out)
 }
@@ -1,8 +1,10 @@
 package formatters
 
 import (
+	"fmt"
 	"io"
 	"os/exec"
+	"regexp"
 )
 
 type ClangFormatter struct{}
@@ -20,10 +22,61 @@ func (F *ClangFormatter) IsInstalled() bool {
 	return err == nil
 }
 
+func (F *ClangFormatter) Version() (string, error) {
+	return commandVersion("clang-format", "--version")
+}
+
+func (F *ClangFormatter) StyleConfigFiles() []string {
+	return []string{".clang-format", "_clang-format"}
+}
+
+// Returns the command for formatting stdin. The file's path is passed along so
+// that clang-format picks the language and the .clang-format file the same way
+// as it does for files given on the command line, such as in
+// ListUnformatted().
+func clangStdinCommand(file string) []string {
+	cmd := []string{"clang-format"}
+	if len(file) > 0 {
+		cmd = append(cmd, "--assume-filename="+file)
+	}
+	return cmd
+}
+
 func (F *ClangFormatter) FormatToBuffer(args []string, file string, in io.Reader, out io.Writer) error {
-	return runIOCommand(append([]string{"clang-format"}, args...), in, out)
+	return runIOCommand(append(clangStdinCommand(file), args...), in, out)
+}
+
+func (F *ClangFormatter) FormatRangesToBuffer(args []string, file string, ranges []LineRange, in io.Reader, out io.Writer) error {
+	cmd := clangStdinCommand(file)
+	for _, r := range ranges {
+		cmd = append(cmd, fmt.Sprintf("--lines=%d:%d", r.Start, r.End))
+	}
+	return runIOCommand(append(cmd, args...), in, out)
 }
 
 func (F *ClangFormatter) FormatInPlace(args []string, file string) error {
 	return runIOCommand(append([]string{"clang-format", "-i", file}, args...), nil, nil)
 }
+
+// Matches the warnings printed by --dry-run, for example:
+// main.cpp:3:10: warning: code should be clang-formatted [-Wclang-format-violations]
+var clangViolationRegex = regexp.MustCompile(`^(.+):\d+:\d+: (?:warning|error): code should be clang-formatted`)
+
+func (F *ClangFormatter) ListUnformatted(args []string, files []string) ([]string, error) {
+	cmd := append(append([]string{"clang-format", "--dry-run"}, args...), files...)
+	_, stderr, err := runBatchCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	// there's a warning for every violation, so files can be listed many times
+	var listed []string
+	seen := make(map[string]bool)
+	for _, line := range outputLines(stderr) {
+		if m := clangViolationRegex.FindStringSubmatch(line); m != nil && !seen[m[1]] {
+			seen[m[1]] = true
+			listed = append(listed, m[1])
+		}
+	}
+	return matchListedFiles(files, listed)
+}
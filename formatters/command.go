@@ -0,0 +1,194 @@
+package formatters
+
+// Formatters defined in the config file by the commands used to run them.
+
+import (
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+type CommandFormatter struct {
+	name       string
+	extensions []string
+	// Command templates split into arguments. See NewCommandFormatter().
+	command        []string
+	inPlaceCommand []string
+	installCheck   []string
+	// Directory that the commands are run in, which relative program paths
+	// are relative to
+	dir string
+
+	installedOnce sync.Once
+	installed     bool
+}
+
+// Creates a formatter that runs external commands. In the command templates,
+// "{file}" is replaced with the path of the file being formatted and an
+// argument of "{args}" is replaced with the formatter's arguments from the
+// config. If there's no "{args}", the arguments are added after the program
+// name. Templates are split into arguments like a shell would, but aren't run
+// by a shell.
+// @param extensions file extensions (".sh") or glob patterns ("*.bash",
+// "Dockerfile*") of files to format
+// @param command reads the file content on stdin and writes the formatted
+// content to stdout
+// @param inPlaceCommand optional, formats the file in place. If empty, the
+// stdin command is used and the file is rewritten.
+// @param installCheck optional, exits successfully if the formatter is
+// installed. If empty, the formatter is installed if its program is in PATH.
+// @param dir directory of the config declaring the formatter. Commands are run
+// in it, and relative program paths like "./fmt.sh" are relative to it.
+func NewCommandFormatter(name string, extensions []string, command, inPlaceCommand, installCheck, dir string) (*CommandFormatter, error) {
+	if len(name) == 0 {
+		return nil, errors.New("Custom formatter is missing a name")
+	}
+	if len(extensions) == 0 {
+		return nil, errors.Errorf("Custom formatter %s has no extensions", name)
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	F := &CommandFormatter{name: name, extensions: extensions, dir: absDir}
+	if F.command, err = splitCommand(command); err != nil {
+		return nil, errors.Wrapf(err, "Invalid command for custom formatter %s", name)
+	}
+	if len(F.command) == 0 {
+		return nil, errors.Errorf("Custom formatter %s has no command", name)
+	}
+	if F.inPlaceCommand, err = splitCommand(inPlaceCommand); err != nil {
+		return nil, errors.Wrapf(err, "Invalid in-place command for custom formatter %s", name)
+	}
+	if F.installCheck, err = splitCommand(installCheck); err != nil {
+		return nil, errors.Wrapf(err, "Invalid install check for custom formatter %s", name)
+	}
+	for _, cmd := range [][]string{F.command, F.inPlaceCommand, F.installCheck} {
+		if len(cmd) > 0 && strings.ContainsRune(cmd[0], filepath.Separator) && !filepath.IsAbs(cmd[0]) {
+			cmd[0] = filepath.Join(absDir, cmd[0])
+		}
+	}
+	return F, nil
+}
+
+func (F *CommandFormatter) Name() string {
+	return F.name
+}
+
+func (F *CommandFormatter) FileExtensions() []string {
+	return F.extensions
+}
+
+func (F *CommandFormatter) IsInstalled() bool {
+	F.installedOnce.Do(func() {
+		if len(F.installCheck) > 0 {
+			F.installed = runIOCommandInDir(F.dir, F.installCheck, nil, nil) == nil
+		} else {
+			_, err := exec.LookPath(F.command[0])
+			F.installed = err == nil
+		}
+	})
+	return F.installed
+}
+
+// Custom formatters don't have a standard way to report their version, so
+// they're identified by their binary and command templates instead.
+func (F *CommandFormatter) Version() (string, error) {
+	fingerprint, err := binaryFingerprint(F.command[0])
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		fingerprint,
+		strings.Join(F.command, " "),
+		strings.Join(F.inPlaceCommand, " "),
+	}, "\n"), nil
+}
+
+func (F *CommandFormatter) FormatToBuffer(args []string, file string, in io.Reader, out io.Writer) error {
+	return runIOCommandInDir(F.dir, expandCommand(F.command, args, file), in, out)
+}
+
+func (F *CommandFormatter) FormatInPlace(args []string, file string) error {
+	if len(F.inPlaceCommand) > 0 {
+		return runIOCommandInDir(F.dir, expandCommand(F.inPlaceCommand, args, file), nil, nil)
+	}
+
+	return rewriteFile(file, func(in io.Reader, out io.Writer) error {
+		return F.FormatToBuffer(args, file, in, out)
+	})
+}
+
+// Fills in a command template for the given file and formatter arguments.
+func expandCommand(template []string, args []string, file string) []string {
+	var cmd []string
+	hasArgs := false
+	for _, token := range template {
+		if token == "{args}" {
+			hasArgs = true
+			cmd = append(cmd, args...)
+			continue
+		}
+		cmd = append(cmd, strings.Replace(token, "{file}", file, -1))
+	}
+	if !hasArgs && len(args) > 0 {
+		cmd = append(append([]string{cmd[0]}, args...), cmd[1:]...)
+	}
+	return cmd
+}
+
+// Splits a command into arguments. Arguments are separated by whitespace and
+// can be quoted with single or double quotes. Outside of single quotes, a
+// backslash escapes the next character.
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, c := range command {
+		switch {
+		case escaped:
+			current.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.Errorf("Unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, errors.New("Trailing backslash")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
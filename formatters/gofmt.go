@@ -19,6 +19,11 @@ func (F *GofmtFormatter) IsInstalled() bool {
 	return true
 }
 
+func (F *GofmtFormatter) Version() (string, error) {
+	// gofmt has no version flag, so identify the binary itself
+	return binaryFingerprint("gofmt")
+}
+
 func (F *GofmtFormatter) FormatToBuffer(args []string, file string, in io.Reader, out io.Writer) error {
 	return runIOCommand([]string{"gofmt"}, in, out)
 }
@@ -26,3 +31,11 @@ func (F *GofmtFormatter) FormatToBuffer(args []string, file string, in io.Reader
 func (F *GofmtFormatter) FormatInPlace(args []string, absPath string) error {
 	return runIOCommand([]string{"gofmt", "-l", "-w", absPath}, nil, nil)
 }
+
+func (F *GofmtFormatter) ListUnformatted(args []string, files []string) ([]string, error) {
+	stdout, _, err := runBatchCommand(append([]string{"gofmt", "-l"}, files...))
+	if err != nil {
+		return nil, err
+	}
+	return matchListedFiles(files, outputLines(stdout))
+}
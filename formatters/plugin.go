@@ -0,0 +1,108 @@
+package formatters
+
+// Adapts external formatter plugins to the Formatter interface. See the
+// plugin package for the protocol.
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/justbuchanan/stylize/plugin"
+	"github.com/pkg/errors"
+)
+
+type PluginFormatter struct {
+	client *plugin.Client
+	desc   plugin.DescribeResult
+}
+
+// Launches a plugin and asks it to describe itself.
+// @param command the plugin executable followed by its arguments, split the
+// same way as custom formatter commands
+// @param dir directory that a relative executable path is relative to
+func NewPluginFormatter(command string, dir string) (*PluginFormatter, error) {
+	args, err := splitCommand(command)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid plugin command '%s'", command)
+	}
+	if len(args) == 0 {
+		return nil, errors.New("Empty plugin command")
+	}
+	if strings.ContainsRune(args[0], filepath.Separator) && !filepath.IsAbs(args[0]) {
+		args[0] = filepath.Join(dir, args[0])
+	}
+
+	client, err := plugin.Start(args)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := client.Describe()
+	if err == nil && len(desc.Name) == 0 {
+		err = errors.Errorf("Plugin '%s' didn't give a name", command)
+	}
+	if err != nil {
+		client.Shutdown()
+		return nil, err
+	}
+
+	return &PluginFormatter{client: client, desc: desc}, nil
+}
+
+func (F *PluginFormatter) Name() string {
+	return F.desc.Name
+}
+
+func (F *PluginFormatter) FileExtensions() []string {
+	return F.desc.Extensions
+}
+
+func (F *PluginFormatter) IsInstalled() bool {
+	// the plugin is already running
+	return true
+}
+
+func (F *PluginFormatter) Version() (string, error) {
+	if len(F.desc.Version) == 0 {
+		return "", errors.Errorf("Plugin %s has no version", F.desc.Name)
+	}
+	return F.desc.Version, nil
+}
+
+func (F *PluginFormatter) FormatToBuffer(args []string, file string, in io.Reader, out io.Writer) error {
+	_, err := F.FormatWithDiagnostics(args, file, in, out)
+	return err
+}
+
+// Like FormatToBuffer(), but also returns the diagnostics reported by the
+// plugin.
+func (F *PluginFormatter) FormatWithDiagnostics(args []string, file string, in io.Reader, out io.Writer) ([]Diagnostic, error) {
+	content, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := F.client.Format(plugin.FormatParams{Path: file, Content: string(content), Args: args})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Plugin %s failed", F.desc.Name)
+	}
+	var diagnostics []Diagnostic
+	for _, d := range result.Diagnostics {
+		diagnostics = append(diagnostics, Diagnostic{Line: d.Line, Message: d.Message})
+	}
+
+	_, err = io.WriteString(out, result.Content)
+	return diagnostics, err
+}
+
+func (F *PluginFormatter) FormatInPlace(args []string, file string) error {
+	return rewriteFile(file, func(in io.Reader, out io.Writer) error {
+		return F.FormatToBuffer(args, file, in, out)
+	})
+}
+
+// Stops the plugin process.
+func (F *PluginFormatter) Shutdown() error {
+	return F.client.Shutdown()
+}
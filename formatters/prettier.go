@@ -3,8 +3,11 @@ package formatters
 // https://github.com/prettier/prettier
 
 import (
+	"bytes"
 	"io"
+	"io/ioutil"
 	"os/exec"
+	"strconv"
 )
 
 type PrettierFormatter struct{}
@@ -22,10 +25,60 @@ func (F *PrettierFormatter) IsInstalled() bool {
 	return err == nil
 }
 
+func (F *PrettierFormatter) Version() (string, error) {
+	return commandVersion("prettier", "--version")
+}
+
+func (F *PrettierFormatter) StyleConfigFiles() []string {
+	return []string{
+		".prettierrc", ".prettierrc.json", ".prettierrc.yaml", ".prettierrc.yml",
+		".prettierrc.json5", ".prettierrc.toml", ".prettierrc.js", ".prettierrc.cjs",
+		".prettierrc.mjs", "prettier.config.js", "prettier.config.cjs",
+		"prettier.config.mjs", ".editorconfig", "package.json",
+	}
+}
+
 func (F *PrettierFormatter) FormatToBuffer(args []string, file string, in io.Reader, out io.Writer) error {
 	return runIOCommand(append([]string{"prettier", "--stdin-filepath", file}, args...), in, out)
 }
 
+// Prettier only supports formatting a single range at a time, so ranges are
+// formatted one by one starting from the end of the file. That way formatting
+// one range doesn't shift the location of the ones before it.
+func (F *PrettierFormatter) FormatRangesToBuffer(args []string, file string, ranges []LineRange, in io.Reader, out io.Writer) error {
+	content, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	for i := len(ranges) - 1; i >= 0; i-- {
+		// prettier's range is given in (utf-16) characters, not lines
+		start := utf16Offset(content, lineOffset(content, ranges[i].Start))
+		end := utf16Offset(content, lineOffset(content, ranges[i].End+1))
+
+		var formatted bytes.Buffer
+		cmd := []string{"prettier", "--stdin-filepath", file,
+			"--range-start", strconv.Itoa(start), "--range-end", strconv.Itoa(end)}
+		if err := runIOCommand(append(cmd, args...), bytes.NewReader(content), &formatted); err != nil {
+			return err
+		}
+		content = formatted.Bytes()
+	}
+
+	_, err = out.Write(content)
+	return err
+}
+
 func (F *PrettierFormatter) FormatInPlace(args []string, file string) error {
 	return runIOCommand(append([]string{"prettier", "--write", file}, args...), nil, nil)
 }
+
+func (F *PrettierFormatter) ListUnformatted(args []string, files []string) ([]string, error) {
+	// prettier exits with status 1 if any files are listed
+	cmd := append(append([]string{"prettier", "--list-different"}, args...), files...)
+	stdout, _, err := runBatchCommand(cmd, 1)
+	if err != nil {
+		return nil, err
+	}
+	return matchListedFiles(files, outputLines(stdout))
+}
@@ -0,0 +1,138 @@
+package formatters
+
+// Runs prettier as a worker using a small node program that loads prettier's
+// API once and formats requests read from stdin. Requests and responses are
+// JSON objects, one per line.
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const prettierWorkerScript = `
+const readline = require("readline");
+const prettier = require(process.argv[1]);
+// match the command line, which reads ignore files from the working directory
+const ignorePath = parseInt(prettier.version, 10) >= 3 ? [".gitignore", ".prettierignore"] : ".prettierignore";
+const lines = readline.createInterface({ input: process.stdin, terminal: false });
+// handle requests in order, even though formatting is async in prettier 3
+let queue = Promise.resolve();
+lines.on("line", (line) => {
+  queue = queue.then(async () => {
+    const req = JSON.parse(line);
+    let resp;
+    try {
+      const info = await prettier.getFileInfo(req.file, { ignorePath });
+      if (info.ignored) {
+        // the command line outputs ignored files unchanged
+        resp = { content: req.content };
+      } else {
+        const options = (await prettier.resolveConfig(req.file, { editorconfig: true })) || {};
+        options.filepath = req.file;
+        resp = { content: await prettier.format(req.content, options) };
+      }
+    } catch (e) {
+      resp = { error: String((e && e.message) || e) };
+    }
+    process.stdout.write(JSON.stringify(resp) + "\n");
+  });
+});
+`
+
+type prettierWorker struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+type prettierWorkerRequest struct {
+	File    string `json:"file"`
+	Content string `json:"content"`
+}
+
+type prettierWorkerResponse struct {
+	Content string `json:"content"`
+	Error   string `json:"error"`
+}
+
+// Returns the directory of the prettier package that the prettier executable
+// belongs to.
+func prettierModuleDir() (string, error) {
+	bin, err := exec.LookPath("prettier")
+	if err != nil {
+		return "", err
+	}
+	if bin, err = filepath.EvalSymlinks(bin); err != nil {
+		return "", err
+	}
+	for dir := filepath.Dir(bin); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if filepath.Base(dir) != "prettier" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, "package.json")); err == nil {
+			return dir, nil
+		}
+	}
+	return "", errors.Errorf("Couldn't find the prettier package for %s", bin)
+}
+
+func (F *PrettierFormatter) StartWorker() (Worker, error) {
+	moduleDir, err := prettierModuleDir()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command("node", "-e", prettierWorkerScript, moduleDir)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &prettierWorker{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+func (W *prettierWorker) Format(args []string, file string, content []byte) ([]byte, error) {
+	// command line arguments can't be passed to prettier's api
+	if len(args) > 0 {
+		return nil, ErrWorkerUnsupported
+	}
+
+	req, err := json.Marshal(prettierWorkerRequest{File: file, Content: string(content)})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = W.stdin.Write(append(req, '\n')); err != nil {
+		return nil, errors.Wrap(ErrWorkerCrashed, err.Error())
+	}
+
+	line, err := W.stdout.ReadBytes('\n')
+	if err != nil {
+		return nil, errors.Wrap(ErrWorkerCrashed, err.Error())
+	}
+	var resp prettierWorkerResponse
+	if err = json.Unmarshal(line, &resp); err != nil {
+		return nil, errors.Wrap(ErrWorkerCrashed, err.Error())
+	}
+	if len(resp.Error) > 0 {
+		return nil, errors.New(resp.Error)
+	}
+	return []byte(resp.Content), nil
+}
+
+func (W *prettierWorker) Close() error {
+	W.stdin.Close()
+	return W.cmd.Wait()
+}
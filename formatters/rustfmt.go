@@ -20,6 +20,14 @@ func (F *RustfmtFormatter) IsInstalled() bool {
 	return err == nil
 }
 
+func (F *RustfmtFormatter) Version() (string, error) {
+	return commandVersion("rustfmt", "--version")
+}
+
+func (F *RustfmtFormatter) StyleConfigFiles() []string {
+	return []string{"rustfmt.toml", ".rustfmt.toml"}
+}
+
 func (F *RustfmtFormatter) FormatToBuffer(args []string, file string, in io.Reader, out io.Writer) error {
 	return runIOCommand(append([]string{"rustfmt"}, args...), in, out)
 }
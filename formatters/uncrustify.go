@@ -20,6 +20,14 @@ func (F *UncrustifyFormatter) IsInstalled() bool {
 	return err == nil
 }
 
+func (F *UncrustifyFormatter) Version() (string, error) {
+	return commandVersion("uncrustify", "--version")
+}
+
+func (F *UncrustifyFormatter) StyleConfigFiles() []string {
+	return []string{"uncrustify.cfg", ".uncrustify.cfg"}
+}
+
 func (F *UncrustifyFormatter) FormatToBuffer(args []string, file string, in io.Reader, out io.Writer) error {
 	return runIOCommand(append([]string{"uncrustify", "-q"}, args...), in, out)
 }
@@ -2,17 +2,30 @@ package formatters
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"unicode/utf16"
 
 	"github.com/pkg/errors"
 )
 
 // Helper method that wraps exec.Command
 func runIOCommand(args []string, in io.Reader, out io.Writer) error {
+	return runIOCommandInDir("", args, in, out)
+}
+
+// Like runIOCommand(), but runs the command in the given directory. An empty
+// dir means the current working directory.
+func runIOCommandInDir(dir string, args []string, in io.Reader, out io.Writer) error {
 	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
 	cmd.Stdin = in
 	cmd.Stdout = out
 	var stderr bytes.Buffer
@@ -25,3 +38,198 @@ func runIOCommand(args []string, in io.Reader, out io.Writer) error {
 
 	return nil
 }
+
+// Formats a file in place for formatters that can only format from stdin to
+// stdout. The file is only written if its content changes.
+func rewriteFile(file string, format func(in io.Reader, out io.Writer) error) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var formatted bytes.Buffer
+	if err = format(bytes.NewReader(content), &formatted); err != nil {
+		return err
+	}
+	if bytes.Equal(content, formatted.Bytes()) {
+		return nil
+	}
+	return WriteFileAtomic(file, formatted.Bytes())
+}
+
+// Replaces the content of an existing file. The new content is written to a
+// temp file in the same directory, which is then renamed over the original so
+// that the file is never left partially written. Symlinks are followed so that
+// their target is replaced, and the file's permissions and ownership are kept.
+func WriteFileAtomic(absPath string, content []byte) error {
+	target, err := filepath.EvalSymlinks(absPath)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+".stylize-")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err = tmp.Write(content); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpPath, fi.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+
+	// The temp file is owned by the current user. If the original has a
+	// different owner that can't be kept, overwrite the original in place
+	// instead, which keeps its owner but isn't atomic.
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok && (int(stat.Uid) != os.Getuid() || int(stat.Gid) != os.Getgid()) {
+		if err = os.Chown(tmpPath, int(stat.Uid), int(stat.Gid)); err != nil {
+			log.Printf("Warning: can't keep the owner of %s, so it's overwritten in place instead of being replaced atomically: %v", target, err)
+			return ioutil.WriteFile(target, content, fi.Mode())
+		}
+	}
+
+	if err = os.Rename(tmpPath, target); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// Runs a command that operates on multiple files and returns its stdout and
+// stderr. Exit codes in okCodes aren't treated as errors, since some tools use
+// them to signal that files need formatting.
+func runBatchCommand(args []string, okCodes ...int) (string, string, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		for _, code := range okCodes {
+			if exitErr.ExitCode() == code {
+				err = nil
+			}
+		}
+	}
+	if err != nil {
+		return "", "", errors.Wrap(err, stderr.String())
+	}
+	return stdout.String(), stderr.String(), nil
+}
+
+// Maps file paths printed by a tool back to the given files. Returns an error
+// if a printed path isn't one of the files.
+func matchListedFiles(files []string, listed []string) ([]string, error) {
+	byPath := make(map[string]string)
+	for _, file := range files {
+		absPath, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		byPath[absPath] = file
+	}
+
+	var matched []string
+	for _, path := range listed {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		file, ok := byPath[absPath]
+		if !ok {
+			return nil, errors.Errorf("Unexpected file in output: %s", path)
+		}
+		matched = append(matched, file)
+	}
+	return matched, nil
+}
+
+// Returns the non-empty lines of the given output.
+func outputLines(output string) []string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Runs the given command and returns its output, which is expected to contain a
+// version string.
+func commandVersion(args ...string) (string, error) {
+	var out bytes.Buffer
+	if err := runIOCommand(args, nil, &out); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// Identifies a binary by its path, size, and modification time. This is used
+// for tools that don't report a version.
+func binaryFingerprint(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %d %d", path, fi.Size(), fi.ModTime().UnixNano()), nil
+}
+
+// A range of lines in a file. Line numbers start at 1 and both ends are
+// inclusive.
+type LineRange struct {
+	Start, End int
+}
+
+// A problem that a formatter found in a file, but didn't fix.
+type Diagnostic struct {
+	// Line number starting at 1, or 0 if the diagnostic applies to the whole
+	// file.
+	Line    int
+	Message string
+}
+
+// Returns the byte offset of the start of the given line (starting at 1). If
+// the line is past the end of the content, returns the length of the content.
+func lineOffset(content []byte, line int) int {
+	offset := 0
+	for i := 1; i < line; i++ {
+		next := bytes.IndexByte(content[offset:], '\n')
+		if next < 0 {
+			return len(content)
+		}
+		offset += next + 1
+	}
+	return offset
+}
+
+// Converts a byte offset into utf-8 content to an offset in utf-16 code units,
+// which is what javascript-based tools use to index strings.
+func utf16Offset(content []byte, byteOffset int) int {
+	offset := 0
+	for _, r := range string(content[:byteOffset]) {
+		offset += utf16.RuneLen(r)
+	}
+	return offset
+}
@@ -0,0 +1,70 @@
+package formatters
+
+import (
+	"net"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A long-running formatter server that can format many files, which avoids
+// paying the tool's startup cost for each one. Workers are used by one
+// goroutine at a time.
+type Worker interface {
+	// Formats content as if it were the contents of the given file.
+	Format(args []string, file string, content []byte) ([]byte, error)
+	// Stops the worker.
+	Close() error
+}
+
+var (
+	// Returned by workers when communication with the worker fails. The
+	// worker can't be used again.
+	ErrWorkerCrashed = errors.New("worker crashed")
+	// Returned by workers that can't handle a request, such as one with
+	// arguments they don't understand. The file should be formatted with the
+	// formatter's regular command instead.
+	ErrWorkerUnsupported = errors.New("request not supported by worker")
+)
+
+// How long to wait for a worker to start accepting requests.
+const workerStartTimeout = 10 * time.Second
+
+// Returns a port on localhost that's currently free.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Waits until a server started by cmd accepts connections on the given port.
+// Returns an error if the process exits or doesn't start listening in time.
+func waitForPort(cmd *exec.Cmd, port int) error {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	deadline := time.Now().Add(workerStartTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-exited:
+			return errors.Wrapf(err, "%s exited", cmd.Path)
+		default:
+		}
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	cmd.Process.Kill()
+	return errors.Errorf("Timed out waiting for %s to start", cmd.Path)
+}
@@ -1,6 +1,7 @@
 package formatters
 
 import (
+	"fmt"
 	"io"
 	"os/exec"
 )
@@ -20,11 +21,27 @@ func (F *YapfFormatter) IsInstalled() bool {
 	return err == nil
 }
 
+func (F *YapfFormatter) Version() (string, error) {
+	return commandVersion("yapf", "--version")
+}
+
+func (F *YapfFormatter) StyleConfigFiles() []string {
+	return []string{".style.yapf", "setup.cfg", "pyproject.toml"}
+}
+
 func (F *YapfFormatter) FormatToBuffer(args []string, file string, in io.Reader, out io.Writer) error {
 	args2 := append([]string{"yapf"}, args...)
 	return runIOCommand(args2, in, out)
 }
 
+func (F *YapfFormatter) FormatRangesToBuffer(args []string, file string, ranges []LineRange, in io.Reader, out io.Writer) error {
+	cmd := []string{"yapf"}
+	for _, r := range ranges {
+		cmd = append(cmd, "--lines", fmt.Sprintf("%d-%d", r.Start, r.End))
+	}
+	return runIOCommand(append(cmd, args...), in, out)
+}
+
 func (F *YapfFormatter) FormatInPlace(args []string, file string) error {
 	return runIOCommand(append([]string{"yapf", "-i", file}, args...), nil, nil)
 }
@@ -6,13 +6,13 @@ toolchain go1.24.5
 
 require (
 	github.com/bradfitz/slice v0.0.0-20180809154707-2b758aa73013
-	github.com/danwakefield/fnmatch v0.0.0-20160403171240-cbb64ac3d964
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/pkg/errors v0.9.1
 	github.com/pmezard/go-difflib v1.0.0
 	go4.org v0.0.0-20230225012048-214862532bf5 // indirect
 	golang.org/x/crypto v0.42.0
 	golang.org/x/sys v0.36.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require golang.org/x/term v0.35.0 // indirect
@@ -6,84 +6,307 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"github.com/justbuchanan/stylize/stylize"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
-func main() {
-	// Remove date/time from logs
-	log.SetFlags(0)
+// Flags shared by all commands that check or format files.
+type contextFlags struct {
+	inPlace          bool
+	configFile       string
+	dir              string
+	exclude          string
+	respectGitignore bool
+	parallelism      int
+	noCache          bool
+	workers          bool
+	showDiff         bool
+	summary          bool
+}
 
-	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, "Stylize - code formatting tool")
-		flag.PrintDefaults()
+func addContextFlags(fs *flag.FlagSet) *contextFlags {
+	f := &contextFlags{}
+	fs.BoolVar(&f.inPlace, "i", false, "[WARNING] There's no undo button, make a commit first. If enabled, formats files in place. Default behavior is just to check which files need formatting.")
+	fs.StringVar(&f.configFile, "config", "", "Optional config file. Defaults to the outermost .stylize.yml in --dir or its parents, up to the top of the git repo, in which case paths are relative to the config's directory and --dir only limits which files are examined.")
+	fs.StringVar(&f.configFile, "c", "", "Alias for --config")
+	fs.StringVar(&f.dir, "dir", ".", "Directory to recursively format.")
+	fs.StringVar(&f.exclude, "exclude", "", "A list of exclude patterns (comma-separated). These follow the same rules as .gitignore files.")
+	fs.BoolVar(&f.respectGitignore, "respect_gitignore", false, "Also exclude files ignored by .gitignore files.")
+	fs.IntVar(&f.parallelism, "j", 8, "Number of files to process in parallel.")
+	fs.BoolVar(&f.noCache, "no_cache", false, "Disable the cache of files known to be formatted.")
+	fs.BoolVar(&f.workers, "workers", false, "Keep formatters that support it (black, prettier) running as worker processes instead of starting them for each file. Up to -j workers are run per formatter.")
+	fs.BoolVar(&f.showDiff, "show_diff", false, "Print the changes that each file needs. Colorized when stderr is a terminal, unless NO_COLOR is set.")
+	fs.BoolVar(&f.summary, "summary", true, "Print a table of stats for each formatter after checking all files. Use --summary=false to disable.")
+	return f
+}
+
+// Returns the config file to use, the root directory that files and settings
+// are relative to, and the absolute path of --dir. Without --config, the
+// outermost config in --dir or its parents, up to the top of the git repo, is
+// used and its directory becomes the root. Configs below it are nested configs.
+func (f *contextFlags) findConfig() (string, string, string, error) {
+	dir, err := filepath.Abs(f.dir)
+	if err != nil {
+		return "", "", "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
 	}
-	inPlaceFlag := flag.Bool("i", false, "[WARNING] There's no undo button, make a commit first. If enabled, formats files in place. Default behavior is just to check which files need formatting.")
-	var patchFile string
-	flag.StringVar(&patchFile, "patch_output", "", "Path to output patch to. If '-', writes to stdout.")
-	flag.StringVar(&patchFile, "o", "", "Alias for --patch_output")
-	var configFile string
-	flag.StringVar(&configFile, "config", ".stylize.yml", "Optional config file (defaults to .stylize.yml).")
-	flag.StringVar(&configFile, "c", ".stylize.yml", "Alias for --config")
-	dirFlag := flag.String("dir", ".", "Directory to recursively format.")
-	excludeFlag := flag.String("exclude", "", "A list of exclude patterns (comma-separated).")
-	var diffbase string
-	flag.StringVar(&diffbase, "git_diffbase", "", "If provided, stylize only looks at files that differ from the given commit/branch.")
-	flag.StringVar(&diffbase, "g", "", "Alias for git_diffbase")
-	parallelismFlag := flag.Int("j", 8, "Number of files to process in parallel.")
-	printFormattersFlag := flag.Bool("print_formatters", false, "Print map of file extension to formatter, then exit.")
-	flag.Parse()
 
-	// Read config file
-	cfg, err := LoadConfig(configFile)
+	if len(f.configFile) > 0 {
+		return f.configFile, dir, dir, nil
+	}
+	if configFile := stylize.FindConfig(dir); len(configFile) > 0 {
+		return configFile, filepath.Dir(configFile), dir, nil
+	}
+	return "", dir, dir, nil
+}
+
+// Reads the config file and sets up a context based on it and the flags.
+func (f *contextFlags) loadContext() (ctx *stylize.StylizeContext, err error) {
+	// plugins are started before the config is checked, so they need to be
+	// shut down if anything fails after that
+	defer func() {
+		if err != nil {
+			stylize.ShutdownPlugins()
+		}
+	}()
+
+	configFile, rootDir, dir, err := f.findConfig()
 	if err != nil {
-		if os.IsNotExist(err) {
-			// log.Print("No config file")
-		} else {
-			log.Fatal(err)
+		return nil, err
+	}
+
+	// Read config file
+	var cfg *stylize.Config
+	if len(configFile) > 0 {
+		if cfg, err = stylize.LoadConfig(configFile); err != nil {
+			return nil, err
 		}
-	} else {
 		log.Printf("Loaded config from file %s", configFile)
+		if err = cfg.RegisterCustomFormatters(); err != nil {
+			return nil, err
+		}
+		if err = cfg.RegisterPlugins(); err != nil {
+			return nil, err
+		}
+		if err = cfg.Check(false); err != nil {
+			return nil, err
+		}
 	}
 
-	ctx := StylizeContext{
-		GitDiffbase: diffbase,
-		InPlace:     *inPlaceFlag,
-		Parallelism: *parallelismFlag,
+	ctx = &stylize.StylizeContext{
+		InPlace:     f.inPlace,
+		Parallelism: f.parallelism,
+		Workers:     f.workers,
+		ShowDiff:    f.showDiff,
+		Summary:     f.summary,
+		RootDir:     rootDir,
+		ConfigFile:  configFile,
 	}
 
-	if ctx.RootDir, err = filepath.Abs(*dirFlag); err != nil {
-		log.Fatal(err)
+	// when the config is in a parent of --dir, only files under --dir are
+	// examined
+	if subdir, err := filepath.Rel(rootDir, dir); err == nil && subdir != "." {
+		ctx.Subdir = subdir
 	}
 
+	// configs in directories under the root are merged with the main one
+	ctx.Configs = stylize.NewConfigTree(ctx.RootDir, configFile)
+
 	// Exclude common vcs directories
 	ctx.Exclude = append(ctx.Exclude, ".git", ".hg")
+	ctx.IgnoreFiles = []string{stylize.StylizeIgnoreFile}
 
 	if cfg != nil {
 		ctx.Exclude = append(ctx.Exclude, cfg.ExcludePatterns...)
 		ctx.FormatterArgs = cfg.FormatterArgs
+		ctx.CaseInsensitiveExtensions = cfg.CaseInsensitiveExtensions
+	}
+	if f.respectGitignore || (cfg != nil && cfg.RespectGitignore) {
+		ctx.IgnoreFiles = append(ctx.IgnoreFiles, ".gitignore")
 	}
 
 	// exclude dirs from flag
-	if len(*excludeFlag) > 0 {
-		ctx.Exclude = append(ctx.Exclude, strings.Split(*excludeFlag, ",")...)
+	if len(f.exclude) > 0 {
+		ctx.Exclude = append(ctx.Exclude, strings.Split(f.exclude, ",")...)
 	}
 
 	// setup formatters
 	if cfg != nil && cfg.FormattersByExt != nil {
-		ctx.Formatters = LoadFormattersFromMapping(cfg.FormattersByExt)
+		if ctx.Formatters, err = stylize.LoadFormattersFromMapping(cfg.FormattersByExt); err != nil {
+			return nil, err
+		}
 	} else {
-		ctx.Formatters = LoadDefaultFormatters()
+		ctx.Formatters = stylize.LoadDefaultFormatters()
+	}
+
+	if !f.noCache {
+		if ctx.Cache, err = openCache(); err != nil {
+			log.Printf("Not using cache: %v", err)
+		}
+	}
+
+	return ctx, nil
+}
+
+// Like log.Fatal(), but shuts down plugins first.
+func fatal(v ...interface{}) {
+	stylize.ShutdownPlugins()
+	log.Fatal(v...)
+}
+
+// Like log.Fatalf(), but shuts down plugins first.
+func fatalf(format string, v ...interface{}) {
+	stylize.ShutdownPlugins()
+	log.Fatalf(format, v...)
+}
+
+// Prints the formatters and formatter args that apply in a directory, taking
+// nested configs into account. Defaults to the directory given by --dir. If
+// given a file, its directory is used.
+func printFormatters(ctx *stylize.StylizeContext, args []string) {
+	dir := filepath.Join(ctx.RootDir, ctx.Subdir)
+	if len(args) > 0 {
+		dir = args[0]
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			dir = filepath.Dir(dir)
+		}
+	}
+	byExt, formatterArgs, err := ctx.EffectiveConfig(dir)
+	if err != nil {
+		fatal(err)
 	}
 
+	var keys []string
+	for key := range byExt {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	log.Println("Formatters:")
+	for _, key := range keys {
+		names := stylize.ChainNames(byExt[key])
+		if len(names) == 0 {
+			names = []string{stylize.NoFormatter}
+		}
+		log.Printf("%s: %s\n", key, strings.Join(names, ", "))
+	}
+
+	var names []string
+	for name := range formatterArgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		log.Println("Formatter args:")
+	}
+	for _, name := range names {
+		log.Printf("%s: %s\n", name, strings.Join(formatterArgs[name], " "))
+	}
+}
+
+func main() {
+	// Remove date/time from logs
+	log.SetFlags(0)
+
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "cache":
+			cacheCommand(os.Args[2:])
+			return
+		case "watch":
+			watchCommand(os.Args[2:])
+			return
+		case "lsp":
+			lspCommand(os.Args[2:])
+			return
+		case "apply":
+			applyCommand(os.Args[2:])
+			return
+		case "config":
+			configCommand(os.Args[2:])
+			return
+		case "init":
+			initCommand(os.Args[2:])
+			return
+		}
+	}
+
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Stylize - code formatting tool")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Usage: stylize [flags]")
+		fmt.Fprintln(os.Stderr, "       stylize watch [flags]")
+		fmt.Fprintln(os.Stderr, "       stylize lsp [flags]")
+		fmt.Fprintln(os.Stderr, "       stylize apply [flags] <patch file>")
+		fmt.Fprintln(os.Stderr, "       stylize config show [flags]")
+		fmt.Fprintln(os.Stderr, "       stylize config validate [flags]")
+		fmt.Fprintln(os.Stderr, "       stylize init [flags]")
+		fmt.Fprintln(os.Stderr, "       stylize cache clean")
+		fmt.Fprintln(os.Stderr, "")
+		flag.PrintDefaults()
+	}
+	ctxFlags := addContextFlags(flag.CommandLine)
+	var patchFile string
+	flag.StringVar(&patchFile, "patch_output", "", "Path to output patch to. If '-', writes to stdout.")
+	flag.StringVar(&patchFile, "o", "", "Alias for --patch_output")
+	reportFormatFlag := flag.String("report_format", "", "Format of the report to write to --report_output. One of: "+strings.Join(stylize.ReportFormats(), ", ")+".")
+	reportOutputFlag := flag.String("report_output", "", "Path to write a report of the results to. If '-', writes to stdout.")
+	var diffbase string
+	flag.StringVar(&diffbase, "git_diffbase", "", "If provided, stylize only looks at files that differ from the given commit/branch.")
+	flag.StringVar(&diffbase, "g", "", "Alias for git_diffbase")
+	changedLinesFlag := flag.Bool("changed_lines_only", false, "Only check/format lines that have changed since the git diffbase. Requires --git_diffbase.")
+	stagedFlag := flag.Bool("staged", false, "Check/format the staged content of files with staged changes instead of the working tree. In-place mode updates both the index and the working tree. Useful in pre-commit hooks.")
+	stdinFlag := flag.Bool("stdin", false, "Read content from stdin, format it as if it were the file given by --stdin_filepath, and write the result to stdout.")
+	stdinFilepathFlag := flag.String("stdin_filepath", "", "Path used to pick the formatter and apply exclude patterns in --stdin mode. The file doesn't need to exist.")
+	interactiveFlag := flag.Bool("interactive", false, "Show each change and ask whether to apply it, like `git add -p`. Requires -i.")
+	printFormattersFlag := flag.Bool("print_formatters", false, "Print map of file extension to formatter, then exit. Pass a path after the flags to show the formatters that apply there, including nested configs.")
+	flag.Parse()
+
+	ctx, err := ctxFlags.loadContext()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx.GitDiffbase = diffbase
+	ctx.ChangedLinesOnly = *changedLinesFlag
+	ctx.Staged = *stagedFlag
+
 	if *printFormattersFlag {
-		log.Println("Formatters:")
-		for ext, formatter := range ctx.Formatters {
-			log.Printf("%s: %s\n", ext, formatter.Name())
+		printFormatters(ctx, flag.Args())
+		stylize.ShutdownPlugins()
+		os.Exit(0)
+	}
+
+	if *stdinFlag {
+		if len(*stdinFilepathFlag) == 0 {
+			fatal("--stdin_filepath is required with --stdin")
+		}
+		err = stylize.FormatReader(ctx, *stdinFilepathFlag, os.Stdin, os.Stdout)
+		ctx.CloseWorkers()
+		stylize.ShutdownPlugins()
+		if err == stylize.ErrNoFormatter {
+			log.Fatalf("No formatter configured for %s", *stdinFilepathFlag)
+		} else if err != nil {
+			log.Fatal(err)
 		}
 		os.Exit(0)
 	}
 
-	if !*inPlaceFlag && len(patchFile) > 0 {
+	if *interactiveFlag {
+		if !ctx.InPlace {
+			fatal("--interactive requires -i")
+		}
+		if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+			fatal("--interactive requires stdin to be a terminal")
+		}
+		ctx.Reviewer = stylize.NewHunkReviewer(os.Stdin, os.Stderr)
+	}
+
+	if !ctx.InPlace && len(patchFile) > 0 {
 		// Setup patch output writer
 		if patchFile == "-" {
 			ctx.PatchOut = os.Stdout
@@ -91,7 +314,7 @@ func main() {
 		} else {
 			var patchFileOut *os.File
 			if patchFileOut, err = os.Create(patchFile); err != nil {
-				log.Fatal(err)
+				fatal(err)
 			}
 			ctx.PatchOut = patchFileOut
 			defer patchFileOut.Close()
@@ -99,14 +322,40 @@ func main() {
 		}
 	}
 
-	stats := ctx.Run()
+	if len(*reportFormatFlag) > 0 || len(*reportOutputFlag) > 0 {
+		if ctx.Reporter = stylize.LookupReporter(*reportFormatFlag); ctx.Reporter == nil {
+			fatalf("Unknown report format '%s'", *reportFormatFlag)
+		}
+		if len(*reportOutputFlag) == 0 {
+			fatal("--report_output is required when --report_format is given")
+		}
+
+		// Setup report output writer
+		if *reportOutputFlag == "-" {
+			ctx.ReportOut = os.Stdout
+		} else {
+			var reportFileOut *os.File
+			if reportFileOut, err = os.Create(*reportOutputFlag); err != nil {
+				fatal(err)
+			}
+			ctx.ReportOut = reportFileOut
+			defer reportFileOut.Close()
+			log.Printf("Writing %s report to file %s", *reportFormatFlag, *reportOutputFlag)
+		}
+	}
+
+	stats, err := ctx.Run()
+	stylize.ShutdownPlugins()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	if stats.Error > 0 {
 		os.Exit(1)
 	}
 
 	// Signal that files need formatting
-	if !*inPlaceFlag && stats.Change > 0 {
+	if !ctx.InPlace && stats.Change > 0 {
 		os.Exit(2)
 	}
 }
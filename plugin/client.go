@@ -0,0 +1,259 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// How long to wait for a response before assuming that the plugin is stuck.
+const DefaultTimeout = 30 * time.Second
+
+// A connection to a running plugin. A Client can be shared between goroutines.
+// Each plugin process handles one request at a time, so more processes are
+// started as needed for concurrent requests, up to MaxProcesses. Processes
+// that exit unexpectedly or don't respond in time are killed and replaced on
+// the next request.
+type Client struct {
+	command []string
+	// Maximum time to wait for a response to each request
+	Timeout time.Duration
+	// Maximum number of processes to run at once
+	MaxProcesses int
+
+	mutex sync.Mutex
+	// Processes that aren't handling a request
+	idle []*process
+	// Number of running processes, including busy ones
+	running int
+	// Signaled when a process becomes idle or exits
+	available *sync.Cond
+	nextID    int
+}
+
+// A running plugin process
+type process struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// Launches a plugin.
+// @param command the plugin executable followed by its arguments
+func Start(command []string) (*Client, error) {
+	if len(command) == 0 {
+		return nil, errors.New("Empty plugin command")
+	}
+	c := &Client{command: command, Timeout: DefaultTimeout, MaxProcesses: runtime.NumCPU()}
+	c.available = sync.NewCond(&c.mutex)
+	p, err := c.startProcess()
+	if err != nil {
+		return nil, err
+	}
+	c.idle = append(c.idle, p)
+	c.running++
+	return c, nil
+}
+
+func (c *Client) startProcess() (*process, error) {
+	cmd := exec.Command(c.command[0], c.command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "Failed to start plugin '%s'", strings.Join(c.command, " "))
+	}
+	return &process{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+// Kills the process after a communication failure.
+func (p *process) kill() {
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+}
+
+// Returns an idle process, starting a new one if there aren't any and the
+// limit hasn't been reached. Otherwise waits for one to become available.
+func (c *Client) acquire() (*process, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for {
+		if n := len(c.idle); n > 0 {
+			p := c.idle[n-1]
+			c.idle = c.idle[:n-1]
+			return p, nil
+		}
+		if c.running < c.MaxProcesses || c.running == 0 {
+			p, err := c.startProcess()
+			if err != nil {
+				return nil, err
+			}
+			c.running++
+			return p, nil
+		}
+		c.available.Wait()
+	}
+}
+
+// Returns a process to the idle list, or forgets about it if it was killed.
+func (c *Client) release(p *process, alive bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if alive {
+		c.idle = append(c.idle, p)
+	} else {
+		c.running--
+	}
+	c.available.Signal()
+}
+
+// Sends a request to the process and reads the response. Returns true if the
+// process can still be used afterwards.
+func (c *Client) send(p *process, req Request) (*Response, bool, error) {
+	line, err := json.Marshal(req)
+	if err != nil {
+		return nil, true, err
+	}
+
+	type reply struct {
+		resp *Response
+		err  error
+	}
+	done := make(chan reply, 1)
+	go func() {
+		if _, err := p.stdin.Write(append(line, '\n')); err != nil {
+			done <- reply{err: errors.Wrapf(err, "Failed to send request to plugin '%s'", c.command[0])}
+			return
+		}
+		line, err := p.stdout.ReadBytes('\n')
+		if err != nil {
+			done <- reply{err: errors.Wrapf(err, "Failed to read response from plugin '%s'", c.command[0])}
+			return
+		}
+		var resp Response
+		if err = json.Unmarshal(line, &resp); err != nil {
+			done <- reply{err: errors.Wrapf(err, "Invalid response from plugin '%s'", c.command[0])}
+			return
+		}
+		done <- reply{resp: &resp}
+	}()
+
+	var r reply
+	if c.Timeout > 0 {
+		timer := time.NewTimer(c.Timeout)
+		defer timer.Stop()
+		select {
+		case r = <-done:
+		case <-timer.C:
+			// killing the process unblocks the goroutine
+			p.kill()
+			return nil, false, errors.Errorf("Plugin '%s' didn't respond to %s within %v", c.command[0], req.Method, c.Timeout)
+		}
+	} else {
+		r = <-done
+	}
+
+	if r.err != nil {
+		p.kill()
+		return nil, false, r.err
+	}
+	if r.resp.ID != req.ID {
+		p.kill()
+		return nil, false, errors.Errorf("Plugin '%s' responded to request %d instead of %d", c.command[0], r.resp.ID, req.ID)
+	}
+	return r.resp, true, nil
+}
+
+// Sends a request and decodes the response's result into result.
+func (c *Client) call(method string, params interface{}, result interface{}) error {
+	req := Request{JSONRPC: "2.0", Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = raw
+	}
+
+	p, err := c.acquire()
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.nextID++
+	req.ID = c.nextID
+	c.mutex.Unlock()
+
+	resp, alive, err := c.send(p, req)
+	c.release(p, alive)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result != nil {
+		return json.Unmarshal(resp.Result, result)
+	}
+	return nil
+}
+
+func (c *Client) Describe() (DescribeResult, error) {
+	var result DescribeResult
+	err := c.call("describe", nil, &result)
+	return result, err
+}
+
+func (c *Client) Format(params FormatParams) (FormatResult, error) {
+	var result FormatResult
+	err := c.call("format", params, &result)
+	return result, err
+}
+
+// Asks the plugin processes to exit and waits for them to do so. Requests
+// that are in progress are finished first.
+func (c *Client) Shutdown() error {
+	c.mutex.Lock()
+	for len(c.idle) < c.running {
+		c.available.Wait()
+	}
+	idle := c.idle
+	c.idle = nil
+	c.running = 0
+	c.mutex.Unlock()
+
+	var err error
+	for _, p := range idle {
+		c.mutex.Lock()
+		c.nextID++
+		req := Request{JSONRPC: "2.0", ID: c.nextID, Method: "shutdown"}
+		c.mutex.Unlock()
+
+		_, alive, sendErr := c.send(p, req)
+		if alive {
+			p.stdin.Close()
+			if waitErr := p.cmd.Wait(); sendErr == nil {
+				sendErr = waitErr
+			}
+		}
+		if err == nil {
+			err = sendErr
+		}
+	}
+	return err
+}
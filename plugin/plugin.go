@@ -0,0 +1,153 @@
+// Package plugin defines the protocol used by stylize to talk to external
+// formatter plugins.
+//
+// A plugin is a long-lived executable. Stylize writes JSON-RPC 2.0 requests to
+// its stdin and reads responses from its stdout, one JSON message per line.
+// Requests are sent one at a time and each one is answered before the next is
+// sent. To format files in parallel, stylize may start several instances of a
+// plugin. Plugins that don't respond within DefaultTimeout are killed and
+// restarted. The methods are:
+//
+//	describe  -> DescribeResult
+//	format    FormatParams -> FormatResult
+//	shutdown  -> null, after which the plugin should exit
+//
+// Plugins written in Go can implement Handler and call Serve(). Anything
+// written to stderr is passed through to stylize's stderr.
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// JSON-RPC error codes
+const (
+	CodeParseError     = -32700
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	// Returned by format when the content can't be formatted, such as when it
+	// has syntax errors.
+	CodeFormatFailed = 1
+)
+
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int             `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int             `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+type DescribeResult struct {
+	// Formatter name, used in the config file to refer to the plugin.
+	Name string `json:"name"`
+	// File extensions (".py") or glob patterns ("*.bash") the plugin formats
+	// by default.
+	Extensions []string `json:"extensions"`
+	// Optional. If given, results are cached and the cache is invalidated when
+	// the version changes.
+	Version string `json:"version,omitempty"`
+}
+
+type FormatParams struct {
+	// Path of the file being formatted. The file may not exist or may have
+	// different content, so plugins should only use this to pick settings.
+	Path    string `json:"path"`
+	Content string `json:"content"`
+	// Arguments for the formatter from the config's formatter_args.
+	Args []string `json:"args,omitempty"`
+}
+
+type Diagnostic struct {
+	// Line number starting at 1, or 0 if the diagnostic applies to the whole
+	// file.
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+type FormatResult struct {
+	Content     string       `json:"content"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// Implemented by plugins to handle requests.
+type Handler interface {
+	Describe() DescribeResult
+	// Returning an *Error sets the error code sent to stylize.
+	Format(params FormatParams) (FormatResult, error)
+}
+
+// Reads requests from in and writes responses to out until a shutdown request
+// is received or in is closed.
+func Serve(h Handler, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	// content is sent inline, so lines can be long
+	scanner.Buffer(nil, 1<<30)
+	encoder := json.NewEncoder(out)
+
+	for scanner.Scan() {
+		var req Request
+		resp := Response{JSONRPC: "2.0"}
+		var result interface{}
+
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = &Error{Code: CodeParseError, Message: err.Error()}
+		} else {
+			resp.ID = req.ID
+			switch req.Method {
+			case "describe":
+				result = h.Describe()
+			case "format":
+				var params FormatParams
+				if err := json.Unmarshal(req.Params, &params); err != nil {
+					resp.Error = &Error{Code: CodeInvalidParams, Message: err.Error()}
+					break
+				}
+				formatResult, err := h.Format(params)
+				if rpcErr, ok := err.(*Error); ok {
+					resp.Error = rpcErr
+				} else if err != nil {
+					resp.Error = &Error{Code: CodeFormatFailed, Message: err.Error()}
+				} else {
+					result = formatResult
+				}
+			case "shutdown":
+			default:
+				resp.Error = &Error{Code: CodeMethodNotFound, Message: "Method not found: " + req.Method}
+			}
+		}
+
+		if resp.Error == nil {
+			raw, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+			resp.Result = raw
+		}
+		if err := encoder.Encode(resp); err != nil {
+			return err
+		}
+
+		if req.Method == "shutdown" {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
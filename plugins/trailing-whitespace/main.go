@@ -0,0 +1,60 @@
+// A reference stylize plugin that removes trailing whitespace from lines. It's
+// intended as a starting point for writing new plugins.
+//
+// Usage in .stylize.yml:
+//
+//	plugins:
+//	  - path/to/trailing-whitespace --extensions=.txt,.md
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/justbuchanan/stylize/plugin"
+)
+
+type trailingWhitespace struct {
+	extensions []string
+}
+
+func (h *trailingWhitespace) Describe() plugin.DescribeResult {
+	return plugin.DescribeResult{
+		Name:       "trailing-whitespace",
+		Extensions: h.extensions,
+		Version:    "1",
+	}
+}
+
+func (h *trailingWhitespace) Format(params plugin.FormatParams) (plugin.FormatResult, error) {
+	var result plugin.FormatResult
+	lines := strings.SplitAfter(params.Content, "\n")
+	for i, line := range lines {
+		// keep the line ending, including windows-style ones
+		content := strings.TrimRight(line, "\r\n")
+		trimmed := strings.TrimRight(content, " \t")
+		if trimmed != content {
+			result.Diagnostics = append(result.Diagnostics, plugin.Diagnostic{
+				Line:    i + 1,
+				Message: "Removed trailing whitespace",
+			})
+		}
+		lines[i] = trimmed + line[len(content):]
+	}
+	result.Content = strings.Join(lines, "")
+	return result, nil
+}
+
+func main() {
+	extensions := flag.String("extensions", ".txt", "Comma-separated list of file extensions to format by default.")
+	flag.Parse()
+
+	// stdout is used for the protocol, so log to stderr
+	log.SetFlags(0)
+	h := &trailingWhitespace{extensions: strings.Split(*extensions, ",")}
+	if err := plugin.Serve(h, os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,127 @@
+package stylize
+
+// Files handled by a formatter that implements BatchFormatter are run in chunks
+// rather than one at a time. Each chunk is checked with a single invocation of
+// the tool and only files that need formatting are processed further.
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// Upper limit on the number of files passed to one invocation of a tool, which
+// keeps command lines to a reasonable length.
+const maxBatchSize = 100
+
+// Returns true if files with the given chain can be run in batches.
+func (ctx *StylizeContext) canBatch(chain []Formatter) bool {
+	if len(chain) != 1 || ctx.Staged || ctx.ChangedLinesOnly {
+		return false
+	}
+	_, ok := chain[0].(BatchFormatter)
+	return ok
+}
+
+// Splits files into chunks that are spread evenly over the available
+// parallelism.
+func batchChunks(files []string, parallelism int) [][]string {
+	size := (len(files) + parallelism - 1) / parallelism
+	if size > maxBatchSize {
+		size = maxBatchSize
+	} else if size < 1 {
+		size = 1
+	}
+
+	var chunks [][]string
+	for len(files) > 0 {
+		n := size
+		if n > len(files) {
+			n = len(files)
+		}
+		chunks = append(chunks, files[:n])
+		files = files[n:]
+	}
+	return chunks
+}
+
+// Runs formatters on a chunk of files that all use the same BatchFormatter. If
+// the tool fails, each file is run individually instead so that the error is
+// attributed to the right file.
+func (ctx *StylizeContext) runBatch(files []string, chain []Formatter, formatterArgs map[string][]string) []FormattingResult {
+	F := chain[0]
+	B := F.(BatchFormatter)
+	args := formatterArgs[F.Name()]
+
+	runEach := func(files []string) []FormattingResult {
+		var results []FormattingResult
+		for _, file := range files {
+			results = append(results, ctx.runFormatter(file, chain, formatterArgs))
+		}
+		return results
+	}
+
+	// skip files that the cache knows are already formatted
+	var results []FormattingResult
+	var pending, absPaths []string
+	cacheKeys := make(map[string]string)
+	for _, file := range files {
+		absPath := filepath.Join(ctx.RootDir, file)
+		if ctx.Cache != nil {
+			if content, err := ioutil.ReadFile(absPath); err == nil {
+				if key, ok := ctx.Cache.Key(chain, formatterArgs, ctx.RootDir, file, content); ok {
+					if ctx.Cache.IsClean(key) {
+						results = append(results, FormattingResult{FilePath: file, Formatters: ChainNames(chain), Cache: CacheHit})
+						continue
+					}
+					cacheKeys[file] = key
+				}
+			}
+		}
+		pending = append(pending, file)
+		absPaths = append(absPaths, absPath)
+	}
+
+	// a batch of one isn't any faster
+	if len(pending) < 2 {
+		return append(results, runEach(pending)...)
+	}
+
+	start := time.Now()
+	unformattedPaths, err := B.ListUnformatted(args, absPaths)
+	if err != nil {
+		return append(results, runEach(pending)...)
+	}
+	// the time spent checking the batch is shared by its files
+	share := time.Since(start) / time.Duration(len(pending))
+	unformatted := make(map[string]bool)
+	for _, absPath := range unformattedPaths {
+		unformatted[absPath] = true
+	}
+
+	var needFormatting []string
+	for i, file := range pending {
+		if unformatted[absPaths[i]] {
+			needFormatting = append(needFormatting, file)
+			continue
+		}
+
+		result := FormattingResult{FilePath: file, Formatters: ChainNames(chain), Duration: share}
+		if key, ok := cacheKeys[file]; ok {
+			result.Cache = CacheMiss
+			if err := ctx.Cache.MarkClean(key); err != nil {
+				log.Printf("Failed to write cache entry for '%s': %v", file, err)
+			}
+		}
+		results = append(results, result)
+	}
+
+	// Files that need formatting are formatted individually, the same way as
+	// files that aren't batched.
+	for _, r := range runEach(needFormatting) {
+		r.Duration += share
+		results = append(results, r)
+	}
+	return results
+}
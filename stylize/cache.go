@@ -0,0 +1,172 @@
+package stylize
+
+// The result cache remembers files that are known to already be formatted so
+// that later runs can skip invoking the formatter on them. Each entry is an
+// empty marker file whose name is a hash of everything that can affect the
+// formatter's output: the file content, the formatter name and version, the
+// formatter arguments, whether the formatter ran as a worker, and any style
+// config files (.clang-format, etc) found in the file's directory or its
+// ancestors.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type CacheStatus int
+
+const (
+	// The cache wasn't consulted for this result.
+	CacheUnused CacheStatus = iota
+	// The file was known to be formatted and the formatter was skipped.
+	CacheHit
+	// The cache was consulted, but didn't have an entry for the file.
+	CacheMiss
+)
+
+type ResultCache struct {
+	// Directory that cache entries are stored in.
+	Dir string
+
+	mutex sync.Mutex
+	// Formatter versions keyed by formatter name. An empty string means the
+	// version couldn't be determined.
+	versions map[string]string
+	// Contents of style config files keyed by absolute path. A nil entry
+	// means the file doesn't exist.
+	styleFiles map[string][]byte
+}
+
+// Returns the default location of the cache, which is "stylize" inside of the
+// user's cache directory ($XDG_CACHE_HOME or ~/.cache on linux).
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "stylize"), nil
+}
+
+func NewResultCache(dir string) (*ResultCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &ResultCache{
+		Dir:        dir,
+		versions:   make(map[string]string),
+		styleFiles: make(map[string][]byte),
+	}, nil
+}
+
+// Deletes all cache entries.
+func (c *ResultCache) Clean() error {
+	return os.RemoveAll(c.Dir)
+}
+
+func (c *ResultCache) formatterVersion(F Formatter) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	version, ok := c.versions[F.Name()]
+	if !ok {
+		if vf, isVersioned := F.(VersionedFormatter); isVersioned {
+			// an error leaves the version empty, which disables caching
+			version, _ = vf.Version()
+		}
+		c.versions[F.Name()] = version
+	}
+	return version
+}
+
+func (c *ResultCache) readStyleFile(path string) []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	content, ok := c.styleFiles[path]
+	if !ok {
+		content, _ = ioutil.ReadFile(path)
+		c.styleFiles[path] = content
+	}
+	return content
+}
+
+// Hashes all style config files relevant to the formatter in the directory
+// containing file and in each of its ancestors up to rootDir.
+func (c *ResultCache) hashStyleFiles(h hash.Hash, F Formatter, rootDir, file string) {
+	sf, ok := F.(StyleConfigFormatter)
+	if !ok {
+		return
+	}
+
+	dir := filepath.Dir(file)
+	for {
+		for _, name := range sf.StyleConfigFiles() {
+			path := filepath.Join(rootDir, dir, name)
+			if content := c.readStyleFile(path); content != nil {
+				fmt.Fprintf(h, "style %s %d\n", filepath.Join(dir, name), len(content))
+				h.Write(content)
+			}
+		}
+		if dir == "." || dir == "/" {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// Computes the cache key for formatting the given file content with a chain of
+// formatters. Returns false if results for this chain can't be cached.
+// @param formatterArgs formatter arguments keyed by formatter name
+// @param file path relative to rootDir
+func (c *ResultCache) Key(chain []Formatter, formatterArgs map[string][]string, rootDir, file string, content []byte) (string, bool) {
+	h := sha256.New()
+	for _, F := range chain {
+		version := c.formatterVersion(F)
+		if len(version) == 0 {
+			return "", false
+		}
+
+		fmt.Fprintf(h, "formatter %q %q\n", F.Name(), version)
+		// workers don't always produce the same output as the regular
+		// command, such as blackd, which has fewer options than black
+		if _, ok := F.(*pooledFormatter); ok {
+			fmt.Fprintf(h, "worker\n")
+		}
+		for _, arg := range formatterArgs[F.Name()] {
+			fmt.Fprintf(h, "arg %q\n", arg)
+		}
+		c.hashStyleFiles(h, F, rootDir, file)
+	}
+	// The file name can affect the output. For example, prettier uses it to
+	// pick a parser.
+	fmt.Fprintf(h, "name %q\n", filepath.Base(file))
+	fmt.Fprintf(h, "content %d\n", len(content))
+	h.Write(content)
+
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+func (c *ResultCache) entryPath(key string) string {
+	return filepath.Join(c.Dir, key[:2], key)
+}
+
+// Returns true if the key was previously recorded as clean.
+func (c *ResultCache) IsClean(key string) bool {
+	_, err := os.Stat(c.entryPath(key))
+	return err == nil
+}
+
+// Records that the content for the given key is already formatted.
+func (c *ResultCache) MarkClean(key string) error {
+	path := c.entryPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, nil, 0644)
+}
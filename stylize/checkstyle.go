@@ -0,0 +1,88 @@
+package stylize
+
+// Writes reports in the Checkstyle XML format, which many CI systems can
+// display as lint warnings.
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type checkstyleReport struct {
+	XMLName xml.Name         `xml:"checkstyle"`
+	Version string           `xml:"version,attr"`
+	Files   []checkstyleFile `xml:"file"`
+}
+
+type checkstyleFile struct {
+	Name   string            `xml:"name,attr"`
+	Errors []checkstyleError `xml:"error"`
+}
+
+type checkstyleError struct {
+	Line     int    `xml:"line,attr"`
+	Severity string `xml:"severity,attr"`
+	Message  string `xml:"message,attr"`
+	Source   string `xml:"source,attr"`
+}
+
+// Writes one error entry per diff hunk for each file that needs formatting,
+// and one per diagnostic reported by the formatters. Files that couldn't be
+// formatted get a single entry with the formatter's output.
+type CheckstyleReporter struct{}
+
+func (R *CheckstyleReporter) WriteReport(results []FormattingResult, out io.Writer) error {
+	report := checkstyleReport{Version: "4.3"}
+	for _, r := range results {
+		file := checkstyleFile{Name: r.FilePath}
+		source := "stylize." + strings.Join(r.Formatters, "+")
+
+		if r.Error != nil {
+			file.Errors = append(file.Errors, checkstyleError{
+				Line:     1,
+				Severity: "error",
+				Message:  fmt.Sprintf("Error running formatter: %s", r.Error),
+				Source:   source,
+			})
+		} else if r.FormatNeeded {
+			a := splitLines(string(r.Original))
+			b := splitLines(string(r.Formatted))
+			// hunks are grouped the same way as in the patch output
+			for _, group := range groupedChanges(a, b, 3) {
+				first, last := changedLineSpan(group, len(a))
+				message := fmt.Sprintf("Line %d needs formatting", first)
+				if last > first {
+					message = fmt.Sprintf("Lines %d-%d need formatting", first, last)
+				}
+				file.Errors = append(file.Errors, checkstyleError{
+					Line:     first,
+					Severity: "warning",
+					Message:  message,
+					Source:   source,
+				})
+			}
+		}
+
+		if r.Error == nil {
+			for _, d := range r.Diagnostics {
+				file.Errors = append(file.Errors, checkstyleError{
+					// diagnostics for the whole file go on the first line
+					Line:     max(d.Line, 1),
+					Severity: "warning",
+					Message:  d.Message,
+					Source:   "stylize." + d.Formatter,
+				})
+			}
+			sort.SliceStable(file.Errors, func(i, j int) bool {
+				return file.Errors[i].Line < file.Errors[j].Line
+			})
+		}
+
+		report.Files = append(report.Files, file)
+	}
+
+	return writeXML(report, out)
+}
@@ -0,0 +1,545 @@
+package stylize
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/justbuchanan/stylize/formatters"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// An ordered list of formatter names. In the config file this can be given as
+// either a single name or a list of names.
+type FormatterList []string
+
+func (l *FormatterList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = nil
+		if value.Tag != "!!null" {
+			*l = FormatterList{value.Value}
+		}
+		return nil
+	}
+
+	var names []string
+	if err := value.Decode(&names); err != nil {
+		return err
+	}
+	*l = names
+	return nil
+}
+
+// A formatter defined by the commands used to run it. See
+// formatters.NewCommandFormatter() for how commands are written.
+type CustomFormatterConfig struct {
+	Name string `yaml:"name"`
+	// File extensions (".sh") or glob patterns ("*.bash") of files to format.
+	Extensions []string `yaml:"extensions"`
+	// Reads file content on stdin and writes formatted content to stdout.
+	// Example: "shfmt -filename {file}"
+	Command string `yaml:"command"`
+	// Optional command that formats a file in place.
+	// Example: "shfmt -w {file}"
+	InPlaceCommand string `yaml:"in_place_command"`
+	// Optional command that succeeds if the formatter is installed. Defaults to
+	// checking that the command's program is in PATH.
+	InstallCheck string `yaml:"install_check"`
+}
+
+// This type defines the structure of the yml config file for stylize.
+type Config struct {
+	// Other config files that this one builds on, relative to this file. They
+	// are merged in order, so later files take precedence over earlier ones
+	// and this file takes precedence over all of them. Formatters and
+	// formatter_args are overridden by key and exclude patterns are appended.
+	// Example: ["../shared/stylize-base.yml"]
+	Extends []string `yaml:"extends"`
+
+	// Formatters to run keyed by file extension, file name, or glob pattern.
+	// When several keys match a file, the most specific one is used (see
+	// match.go). When multiple formatters are given for a key, they're run in
+	// order with the output of each feeding into the next. "none" leaves
+	// matching files alone.
+	// Example: {".py": ["isort", "black"], "*_pb2.py": "none"}
+	FormattersByExt map[string]FormatterList `yaml:"formatters"`
+	// If true, extensions match regardless of case, so ".cpp" also matches
+	// "main.CPP".
+	CaseInsensitiveExtensions bool `yaml:"case_insensitive_extensions"`
+	// Exclude patterns, which follow the same rules as gitignore files.
+	ExcludePatterns []string `yaml:"exclude"`
+	// If true, files ignored by .gitignore files are also excluded.
+	RespectGitignore bool `yaml:"respect_gitignore"`
+
+	// Formatter arguments keyed by formatter name.
+	// Example: {"clang": ["--style", "google"]}
+	FormatterArgs map[string][]string `yaml:"formatter_args"`
+
+	// Additional formatters, which can be used like the built-in ones.
+	CustomFormatters []CustomFormatterConfig `yaml:"custom_formatters"`
+
+	// Commands that launch formatter plugins. See the plugin package for the
+	// protocol. Relative paths are relative to the config file.
+	// Example: ["tools/my-plugin --verbose"]
+	Plugins []string `yaml:"plugins"`
+	// Optional directory containing plugin executables, all of which are
+	// launched. Relative to the config file.
+	PluginDir string `yaml:"plugin_dir"`
+
+	// Directory containing the config file
+	dir string
+	// Directories that inherited plugin commands are relative to, by index in
+	// Plugins. Plugins declared by this file are relative to dir.
+	pluginDirs map[int]string
+	// Directories of the files that inherited custom formatters were declared
+	// in, by name. Custom formatters declared by this file use dir.
+	customFormatterDirs map[string]string
+	// Where each setting was declared, keyed by setting. See sourceKey().
+	sources map[string]location
+	// Absolute paths of the config file and the files it extends
+	files []string
+}
+
+// Position of a setting in a config file
+type location struct {
+	file         string
+	line, column int
+}
+
+// Returns the key in Config.sources for a setting, such as "formatters/.py" or
+// "exclude/0".
+func sourceKey(section string, key interface{}) string {
+	return fmt.Sprintf("%s/%v", section, key)
+}
+
+// Adds the config's custom formatters to FormatterRegistry. This must be done
+// before formatters are looked up by name.
+func (cfg *Config) RegisterCustomFormatters() error {
+	for _, c := range cfg.CustomFormatters {
+		dir := cfg.dir
+		if d, ok := cfg.customFormatterDirs[c.Name]; ok {
+			dir = d
+		}
+		f, err := formatters.NewCommandFormatter(c.Name, c.Extensions, c.Command, c.InPlaceCommand, c.InstallCheck, dir)
+		if err != nil {
+			return err
+		}
+		RegisterFormatter(f)
+	}
+	return nil
+}
+
+// Launches the config's plugins and adds them to FormatterRegistry. Plugins
+// that replace an already-registered plugin with the same name cause the old
+// one to be shut down.
+func (cfg *Config) RegisterPlugins() error {
+	commands := cfg.Plugins
+	if len(cfg.PluginDir) > 0 {
+		pluginDir := cfg.PluginDir
+		if !filepath.IsAbs(pluginDir) {
+			pluginDir = filepath.Join(cfg.dir, pluginDir)
+		}
+		entries, err := ioutil.ReadDir(pluginDir)
+		if err != nil {
+			return errors.Wrap(err, "Failed to read plugin directory")
+		}
+		// ReadDir returns entries sorted by name
+		for _, entry := range entries {
+			if entry.Mode().IsRegular() && entry.Mode()&0111 != 0 {
+				commands = append(commands, filepath.Join(pluginDir, entry.Name()))
+			}
+		}
+	}
+
+	for i, command := range commands {
+		dir := cfg.dir
+		if d, ok := cfg.pluginDirs[i]; ok {
+			dir = d
+		}
+		f, err := formatters.NewPluginFormatter(command, dir)
+		if err != nil {
+			return err
+		}
+		if existing, ok := LookupFormatter(f.Name()).(*formatters.PluginFormatter); ok {
+			existing.Shutdown()
+		}
+		RegisterFormatter(f)
+	}
+	return nil
+}
+
+// Returns the path of the outermost config file in dir or its parents, up to
+// the top of the git repo containing dir. Configs between it and dir are nested
+// configs (see config_tree.go), so the result is the same as when running from
+// the top of the repo. Outside of a git repo, only dir itself is checked.
+// Returns "" if there isn't a config file.
+// @param dir absolute path of the directory to start in
+func FindConfig(dir string) string {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	top, err := gitRootDir(dir)
+	if err != nil {
+		top = dir
+	}
+
+	found := ""
+	for {
+		file := filepath.Join(dir, ConfigFileName)
+		if fi, err := os.Stat(file); err == nil && !fi.IsDir() {
+			found = file
+		}
+		parent := filepath.Dir(dir)
+		if dir == top || parent == dir {
+			return found
+		}
+		dir = parent
+	}
+}
+
+// Read the config file, along with any files it extends. Check returned error
+// with IsNotExist() to differentiate failure modes.
+func LoadConfig(file string) (*Config, error) {
+	return loadConfig(file, nil)
+}
+
+// @param stack absolute paths of the configs that are extended by this one,
+// which are used to detect cycles.
+func loadConfig(file string, stack []string) (*Config, error) {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range stack {
+		if f == absFile {
+			return nil, errors.Errorf("Config files extend each other in a cycle: %s", strings.Join(append(stack[i:], absFile), " -> "))
+		}
+	}
+
+	fileContent, err := ioutil.ReadFile(file)
+	if err != nil {
+		if len(stack) > 0 && os.IsNotExist(err) {
+			// a missing base is an error, not a missing config
+			return nil, errors.Errorf("Config %s extends %s, which doesn't exist", stack[len(stack)-1], file)
+		}
+		return nil, err
+	}
+
+	var cfg Config
+	root, err := decodeConfig(file, fileContent, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.dir = filepath.Dir(file)
+	cfg.files = []string{absFile}
+	cfg.setSources(file, root)
+	if len(cfg.Extends) == 0 {
+		return &cfg, nil
+	}
+
+	merged := &Config{sources: make(map[string]location), pluginDirs: make(map[int]string), customFormatterDirs: make(map[string]string)}
+	for _, base := range cfg.Extends {
+		if !filepath.IsAbs(base) {
+			base = filepath.Join(cfg.dir, base)
+		}
+		baseCfg, err := loadConfig(base, append(stack, absFile))
+		if err != nil {
+			return nil, err
+		}
+		merged.inherit(baseCfg)
+		for _, f := range baseCfg.files {
+			if !containsString(merged.files, f) {
+				merged.files = append(merged.files, f)
+			}
+		}
+	}
+	merged.inherit(&cfg)
+	merged.Extends = cfg.Extends
+	merged.dir = cfg.dir
+	merged.files = append(merged.files, absFile)
+	return merged, nil
+}
+
+// Returns the absolute paths of the files the config was loaded from,
+// including the ones it extends.
+func (cfg *Config) Files() []string {
+	return cfg.files
+}
+
+// Records where each setting in the config was declared.
+// @param root the config's top-level mapping, or nil if the file is empty
+func (cfg *Config) setSources(file string, root *yaml.Node) {
+	cfg.sources = make(map[string]location)
+	if root == nil {
+		return
+	}
+	at := func(key string, node *yaml.Node) {
+		cfg.sources[key] = location{file, node.Line, node.Column}
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "formatters", "formatter_args":
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				at(sourceKey(key.Value, value.Content[j].Value), value.Content[j])
+			}
+		case "exclude", "plugins":
+			for j, item := range value.Content {
+				at(sourceKey(key.Value, j), item)
+			}
+		case "custom_formatters":
+			for j, item := range value.Content {
+				at(sourceKey(key.Value, cfg.CustomFormatters[j].Name), item)
+			}
+		case "respect_gitignore", "case_insensitive_extensions":
+			// recorded even when false, so that it overrides the base configs
+			at(key.Value, key)
+		case "plugin_dir":
+			if len(cfg.PluginDir) > 0 {
+				at(key.Value, key)
+			}
+		}
+	}
+}
+
+// Merges the settings from other into cfg, with the ones in other taking
+// precedence. Paths in other that are relative to its directory are made
+// absolute.
+func (cfg *Config) inherit(other *Config) {
+	if len(other.FormattersByExt) > 0 && cfg.FormattersByExt == nil {
+		cfg.FormattersByExt = make(map[string]FormatterList)
+	}
+	for key, names := range other.FormattersByExt {
+		cfg.FormattersByExt[key] = names
+		cfg.sources[sourceKey("formatters", key)] = other.sources[sourceKey("formatters", key)]
+	}
+
+	// When two bases extend the same file, its patterns are only added once.
+	// The same pattern declared in different places is kept, since its
+	// position matters when a pattern in between negates it.
+	for i, pattern := range other.ExcludePatterns {
+		source := other.sources[sourceKey("exclude", i)]
+		if cfg.hasExcludeFrom(source) {
+			continue
+		}
+		cfg.sources[sourceKey("exclude", len(cfg.ExcludePatterns))] = source
+		cfg.ExcludePatterns = append(cfg.ExcludePatterns, pattern)
+	}
+
+	if source, ok := other.sources["respect_gitignore"]; ok {
+		cfg.RespectGitignore = other.RespectGitignore
+		cfg.sources["respect_gitignore"] = source
+	}
+
+	if source, ok := other.sources["case_insensitive_extensions"]; ok {
+		cfg.CaseInsensitiveExtensions = other.CaseInsensitiveExtensions
+		cfg.sources["case_insensitive_extensions"] = source
+	}
+
+	if len(other.FormatterArgs) > 0 && cfg.FormatterArgs == nil {
+		cfg.FormatterArgs = make(map[string][]string)
+	}
+	for name, args := range other.FormatterArgs {
+		cfg.FormatterArgs[name] = args
+		cfg.sources[sourceKey("formatter_args", name)] = other.sources[sourceKey("formatter_args", name)]
+	}
+
+	for _, c := range other.CustomFormatters {
+		replaced := false
+		for i := range cfg.CustomFormatters {
+			if cfg.CustomFormatters[i].Name == c.Name {
+				cfg.CustomFormatters[i] = c
+				replaced = true
+			}
+		}
+		if !replaced {
+			cfg.CustomFormatters = append(cfg.CustomFormatters, c)
+		}
+		cfg.customFormatterDirs[c.Name] = other.dir
+		if d, ok := other.customFormatterDirs[c.Name]; ok {
+			cfg.customFormatterDirs[c.Name] = d
+		}
+		cfg.sources[sourceKey("custom_formatters", c.Name)] = other.sources[sourceKey("custom_formatters", c.Name)]
+	}
+
+	for i, command := range other.Plugins {
+		dir := other.dir
+		if d, ok := other.pluginDirs[i]; ok {
+			dir = d
+		}
+		if cfg.hasPlugin(command, dir) {
+			continue
+		}
+		cfg.pluginDirs[len(cfg.Plugins)] = dir
+		cfg.sources[sourceKey("plugins", len(cfg.Plugins))] = other.sources[sourceKey("plugins", i)]
+		cfg.Plugins = append(cfg.Plugins, command)
+	}
+
+	if len(other.PluginDir) > 0 {
+		cfg.PluginDir = other.PluginDir
+		if !filepath.IsAbs(cfg.PluginDir) {
+			cfg.PluginDir = filepath.Join(other.dir, cfg.PluginDir)
+		}
+		cfg.sources["plugin_dir"] = other.sources["plugin_dir"]
+	}
+}
+
+// Returns true if the config already has the exclude pattern declared at the
+// given location, which happens when a file is extended through several paths.
+func (cfg *Config) hasExcludeFrom(source location) bool {
+	if len(source.file) == 0 {
+		return false
+	}
+	for i := range cfg.ExcludePatterns {
+		if cfg.sources[sourceKey("exclude", i)] == source {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the plugin command is already in the config.
+// @param dir directory that the command is relative to
+func (cfg *Config) hasPlugin(command, dir string) bool {
+	for i, c := range cfg.Plugins {
+		d := cfg.dir
+		if pd, ok := cfg.pluginDirs[i]; ok {
+			d = pd
+		}
+		if c == command && d == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a string formatted as a yaml scalar, quoting it if needed.
+func yamlScalar(s string) string {
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Sprintf("%q", s)
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+// Returns a list formatted as a yaml flow sequence, like "[a, b]".
+func yamlFlowList(items []string) string {
+	var quoted []string
+	for _, item := range items {
+		quoted = append(quoted, yamlScalar(item))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// Writes the config in yaml, with a comment on each setting saying which file
+// it came from. Useful for seeing the result of extending other configs.
+// @param relTo directory that paths in the comments are shown relative to
+func (cfg *Config) WriteAnnotated(w io.Writer, relTo string) error {
+	type line struct{ text, source string }
+	var lines []line
+	add := func(text, key string) {
+		source := cfg.sources[key].file
+		if rel, err := filepath.Rel(relTo, source); err == nil && !strings.HasPrefix(rel, "..") {
+			source = rel
+		}
+		lines = append(lines, line{text, source})
+	}
+	sortedKeys := func(m map[string][]string) []string {
+		var keys []string
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	if len(cfg.FormattersByExt) > 0 {
+		lines = append(lines, line{text: "formatters:"})
+		byExt := make(map[string][]string)
+		for key, names := range cfg.FormattersByExt {
+			byExt[key] = names
+		}
+		for _, key := range sortedKeys(byExt) {
+			value := yamlFlowList(byExt[key])
+			if len(byExt[key]) == 1 {
+				value = yamlScalar(byExt[key][0])
+			}
+			add(fmt.Sprintf("  %s: %s", yamlScalar(key), value), sourceKey("formatters", key))
+		}
+	}
+	if cfg.CaseInsensitiveExtensions {
+		add("case_insensitive_extensions: true", "case_insensitive_extensions")
+	}
+	if len(cfg.ExcludePatterns) > 0 {
+		lines = append(lines, line{text: "exclude:"})
+		for i, pattern := range cfg.ExcludePatterns {
+			add("  - "+yamlScalar(pattern), sourceKey("exclude", i))
+		}
+	}
+	if cfg.RespectGitignore {
+		add("respect_gitignore: true", "respect_gitignore")
+	}
+	if len(cfg.FormatterArgs) > 0 {
+		lines = append(lines, line{text: "formatter_args:"})
+		for _, name := range sortedKeys(cfg.FormatterArgs) {
+			add(fmt.Sprintf("  %s: %s", yamlScalar(name), yamlFlowList(cfg.FormatterArgs[name])), sourceKey("formatter_args", name))
+		}
+	}
+	if len(cfg.CustomFormatters) > 0 {
+		lines = append(lines, line{text: "custom_formatters:"})
+		for _, c := range cfg.CustomFormatters {
+			add("  - name: "+yamlScalar(c.Name), sourceKey("custom_formatters", c.Name))
+			lines = append(lines, line{text: "    extensions: " + yamlFlowList(c.Extensions)})
+			lines = append(lines, line{text: "    command: " + yamlScalar(c.Command)})
+			if len(c.InPlaceCommand) > 0 {
+				lines = append(lines, line{text: "    in_place_command: " + yamlScalar(c.InPlaceCommand)})
+			}
+			if len(c.InstallCheck) > 0 {
+				lines = append(lines, line{text: "    install_check: " + yamlScalar(c.InstallCheck)})
+			}
+		}
+	}
+	if len(cfg.Plugins) > 0 {
+		lines = append(lines, line{text: "plugins:"})
+		for i, command := range cfg.Plugins {
+			add("  - "+yamlScalar(command), sourceKey("plugins", i))
+		}
+	}
+	if len(cfg.PluginDir) > 0 {
+		add("plugin_dir: "+yamlScalar(cfg.PluginDir), "plugin_dir")
+	}
+
+	// line up the comments
+	width := 0
+	for _, l := range lines {
+		if len(l.source) > 0 && len(l.text) > width {
+			width = len(l.text)
+		}
+	}
+	for _, l := range lines {
+		var err error
+		if len(l.source) > 0 {
+			_, err = fmt.Fprintf(w, "%-*s  # %s\n", width, l.text, l.source)
+		} else {
+			_, err = fmt.Fprintln(w, l.text)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
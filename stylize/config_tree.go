@@ -0,0 +1,221 @@
+package stylize
+
+// Config files can be placed in any directory under the root. The settings for
+// a file are the merge of the root config and every config from the root down
+// to the file's directory, with deeper configs taking precedence. Nested
+// configs can set formatters, formatter_args, and exclude patterns. Patterns
+// are relative to the directory containing the config that declares them.
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Name of the config files that are read from directories under the root.
+const ConfigFileName = ".stylize.yml"
+
+// The formatters and arguments that apply to the files in a directory.
+type dirSettings struct {
+	// Directory containing the deepest config that contributed to these
+	// settings. Directories without their own config share their parent's
+	// settings.
+	dir           string
+	formatters    map[string][]Formatter
+	formatterArgs map[string][]string
+	// Set if one of the configs couldn't be loaded.
+	err error
+}
+
+// Config files in directories under the root. Configs are read lazily as
+// directories are visited, so it's cheap to construct for a large tree.
+type ConfigTree struct {
+	rootDir string
+	// Absolute path of the root config, which is applied separately and
+	// skipped if it's found in the tree.
+	rootConfig string
+
+	mutex sync.Mutex
+	// Nested configs keyed by directory relative to the root, using forward
+	// slashes. Entries are nil for directories without a config.
+	configs map[string]*Config
+	errs    map[string]error
+	// Merged settings keyed by directory
+	settings map[string]*dirSettings
+}
+
+// @param rootDir absolute path of the root directory
+// @param rootConfig path of the config file that's already been applied to the
+// context, if any. It's skipped if it's in the tree.
+func NewConfigTree(rootDir, rootConfig string) *ConfigTree {
+	if len(rootConfig) > 0 {
+		if abs, err := filepath.Abs(rootConfig); err == nil {
+			rootConfig = abs
+		}
+	}
+	return &ConfigTree{
+		rootDir:    rootDir,
+		rootConfig: rootConfig,
+		configs:    make(map[string]*Config),
+		errs:       make(map[string]error),
+		settings:   make(map[string]*dirSettings),
+	}
+}
+
+// Returns the config in the given directory, or nil if there isn't one. Must be
+// called with the mutex held.
+func (t *ConfigTree) dirConfig(dir string) (*Config, error) {
+	if cfg, ok := t.configs[dir]; ok {
+		return cfg, t.errs[dir]
+	}
+
+	file := filepath.Join(t.rootDir, filepath.FromSlash(dir), ConfigFileName)
+	var cfg *Config
+	var err error
+	if file != t.rootConfig {
+		cfg, err = LoadConfig(file)
+		if os.IsNotExist(err) {
+			cfg, err = nil, nil
+		} else if err == nil {
+			err = cfg.Check(true)
+		}
+		if err != nil {
+			err = errors.Wrapf(err, "Failed to load config %s", file)
+		}
+	}
+	t.configs[dir] = cfg
+	t.errs[dir] = err
+	return cfg, err
+}
+
+// Returns the absolute paths of the nested configs that have been loaded so
+// far and the files they extend.
+func (t *ConfigTree) files() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var files []string
+	for _, cfg := range t.configs {
+		if cfg != nil {
+			files = append(files, cfg.Files()...)
+		}
+	}
+	return files
+}
+
+// Returns the exclude patterns declared by the config in the given directory.
+// @param dir directory relative to the root, using forward slashes. The root
+// itself is "".
+func (t *ConfigTree) excludePatterns(dir string) []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	cfg, _ := t.dirConfig(dir)
+	if cfg == nil {
+		return nil
+	}
+	return cfg.ExcludePatterns
+}
+
+// Returns the merged settings for files in the given directory.
+// @param dir directory relative to the root, using forward slashes
+// @param base settings from the root config
+func (t *ConfigTree) resolve(dir string, base *dirSettings) *dirSettings {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.resolveLocked(dir, base)
+}
+
+func (t *ConfigTree) resolveLocked(dir string, base *dirSettings) *dirSettings {
+	if s, ok := t.settings[dir]; ok {
+		return s
+	}
+
+	parent := base
+	if len(dir) > 0 {
+		parentDir := path.Dir(dir)
+		if parentDir == "." {
+			parentDir = ""
+		}
+		parent = t.resolveLocked(parentDir, base)
+	}
+
+	s := parent
+	cfg, err := t.dirConfig(dir)
+	if err != nil {
+		s = &dirSettings{dir: dir, err: err}
+	} else if parent.err == nil && cfg != nil && (len(cfg.FormattersByExt) > 0 || len(cfg.FormatterArgs) > 0) {
+		s, err = mergeSettings(parent, dir, cfg)
+		if err != nil {
+			s = &dirSettings{dir: dir, err: errors.Wrapf(err, "Invalid config %s", path.Join(dir, ConfigFileName))}
+		}
+	}
+	t.settings[dir] = s
+	return s
+}
+
+// Returns the parent's settings overridden by the ones in cfg. Glob patterns
+// containing a slash are made relative to the root so that they only match
+// files under dir.
+func mergeSettings(parent *dirSettings, dir string, cfg *Config) (*dirSettings, error) {
+	s := &dirSettings{
+		dir:           dir,
+		formatters:    make(map[string][]Formatter),
+		formatterArgs: make(map[string][]string),
+	}
+	for key, chain := range parent.formatters {
+		s.formatters[key] = chain
+	}
+	for name, args := range parent.formatterArgs {
+		s.formatterArgs[name] = args
+	}
+
+	byExt, err := LoadFormattersFromMapping(cfg.FormattersByExt)
+	if err != nil {
+		return nil, err
+	}
+	for key, chain := range byExt {
+		if strings.Contains(key, "/") {
+			key = path.Join(dir, strings.TrimPrefix(key, "/"))
+		}
+		s.formatters[key] = chain
+	}
+	for name, args := range cfg.FormatterArgs {
+		s.formatterArgs[name] = args
+	}
+	return s, nil
+}
+
+// Returns the settings that apply to the given file.
+// @param file path relative to the root. Files outside of the root only use
+// the root config.
+func (ctx *StylizeContext) settingsForFile(file string) *dirSettings {
+	base := &dirSettings{formatters: ctx.Formatters, formatterArgs: ctx.FormatterArgs}
+	if ctx.Configs == nil || filepath.IsAbs(file) {
+		return base
+	}
+	dir := path.Dir(filepath.ToSlash(file))
+	if dir == "." {
+		dir = ""
+	}
+	return ctx.Configs.resolve(dir, base)
+}
+
+// Returns the formatters keyed by extension or pattern and the formatter args
+// that apply to files in the given directory, taking nested configs into
+// account.
+// @param dir path of a directory, either absolute or relative to the working
+// directory
+func (ctx *StylizeContext) EffectiveConfig(dir string) (map[string][]Formatter, map[string][]string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	relDir, _ := ctx.resolvePath(absDir)
+	s := ctx.settingsForFile(filepath.Join(relDir, "file"))
+	return s.formatters, s.formatterArgs, s.err
+}
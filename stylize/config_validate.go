@@ -0,0 +1,342 @@
+package stylize
+
+// Configs are decoded strictly so that typos in setting names are reported
+// instead of silently ignored. Settings that refer to formatters are checked
+// separately by Config.Validate(), once custom formatters and plugins have been
+// registered.
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// An error or warning about a setting in a config file.
+type ConfigProblem struct {
+	File         string
+	Line, Column int
+	Message      string
+	// Warnings are reported, but don't prevent the config from being used.
+	Warning bool
+}
+
+func (p *ConfigProblem) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Message)
+}
+
+func (l location) problem(warning bool, format string, args ...interface{}) *ConfigProblem {
+	return &ConfigProblem{
+		File:    l.file,
+		Line:    l.line,
+		Column:  l.column,
+		Message: fmt.Sprintf(format, args...),
+		Warning: warning,
+	}
+}
+
+// Returns the number of single-character edits needed to turn a into b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// Returns " (did you mean 'x'?)" if one of the candidates is close enough to s
+// to be what was meant, otherwise "".
+func suggestion(s string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, c := range candidates {
+		if d := editDistance(s, c); d < bestDistance && d < len(c)/2+1 {
+			best, bestDistance = c, d
+		}
+	}
+	if len(best) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean '%s'?)", best)
+}
+
+// Returns an error for the first key in the node that doesn't correspond to a
+// field of typ. Values with the wrong type are left for the decoder to report.
+func checkKeys(file string, node *yaml.Node, typ reflect.Type) error {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		fields := make(map[string]reflect.Type)
+		var names []string
+		for i := 0; i < typ.NumField(); i++ {
+			name := strings.Split(typ.Field(i).Tag.Get("yaml"), ",")[0]
+			if len(name) > 0 {
+				fields[name] = typ.Field(i).Type
+				names = append(names, name)
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				l := location{file, key.Line, key.Column}
+				return l.problem(false, "unknown key '%s'%s", key.Value, suggestion(key.Value, names))
+			}
+			if err := checkKeys(file, node.Content[i+1], fieldType); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if node.Kind == yaml.SequenceNode {
+			for _, item := range node.Content {
+				if err := checkKeys(file, item, typ.Elem()); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Map:
+		if node.Kind == yaml.MappingNode {
+			for i := 1; i < len(node.Content); i += 2 {
+				if err := checkKeys(file, node.Content[i], typ.Elem()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Decodes the content of a config file into cfg, rejecting unknown keys.
+// Returns the top-level mapping of the file, or nil if the file is empty.
+func decodeConfig(file string, content []byte, cfg *Config) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse config %s", file)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind == yaml.ScalarNode && root.Tag == "!!null" {
+		return nil, nil
+	}
+	if root.Kind != yaml.MappingNode {
+		l := location{file, root.Line, root.Column}
+		return nil, l.problem(false, "expected a mapping of settings")
+	}
+	if err := checkKeys(file, root, reflect.TypeOf(cfg)); err != nil {
+		return nil, err
+	}
+	if err := root.Decode(cfg); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse config %s", file)
+	}
+	return root, nil
+}
+
+// Returns true if an exclude pattern looks like an absolute path, such as
+// "/home/me/project/build", rather than a pattern like "/build" that's anchored
+// to the config's directory. This only looks at the pattern itself, so that
+// the result doesn't depend on which directories exist on the machine.
+// @param dir directory containing the config that declares the pattern
+func isAbsolutePattern(pattern, dir string) bool {
+	pattern = strings.TrimPrefix(pattern, "!")
+	if len(filepath.VolumeName(pattern)) > 0 || strings.HasPrefix(pattern, "~/") {
+		return true
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absDir = filepath.ToSlash(absDir)
+	return pattern == absDir || strings.HasPrefix(pattern, strings.TrimSuffix(absDir, "/")+"/")
+}
+
+// Returns true if a formatters key without a dot looks like an extension that
+// is missing its dot, such as "ts" or "py3", rather than a file name.
+func looksLikeExtension(key string) bool {
+	if len(key) == 0 || len(key) > 4 {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the names of the formatters that run on at least one kind of file.
+// Without a formatters section, that's the installed formatters that aren't
+// overridden by a higher priority formatter for all of their extensions.
+func (cfg *Config) enabledFormatters() map[string]bool {
+	enabled := make(map[string]bool)
+	if len(cfg.FormattersByExt) > 0 {
+		for _, names := range cfg.FormattersByExt {
+			for _, name := range names {
+				enabled[name] = true
+			}
+		}
+		return enabled
+	}
+
+	claimed := make(map[string]bool)
+	for _, f := range FormatterRegistry {
+		if !f.IsInstalled() {
+			continue
+		}
+		for _, ext := range f.FileExtensions() {
+			if !claimed[ext] {
+				claimed[ext] = true
+				enabled[f.Name()] = true
+			}
+		}
+	}
+	return enabled
+}
+
+// Checks the settings that refer to formatters and files. Formatters are
+// looked up in FormatterRegistry, so custom formatters and plugins must be
+// registered first. Returns the problems sorted by position. The config
+// shouldn't be used if any of them aren't warnings.
+// @param nested true for configs in directories under the root, which only
+// support some settings (see config_tree.go). Since formatters can be enabled
+// by a parent config, formatter_args aren't checked against the enabled ones.
+func (cfg *Config) Validate(nested bool) []*ConfigProblem {
+	var problems []*ConfigProblem
+	add := func(key string, warning bool, format string, args ...interface{}) {
+		problems = append(problems, cfg.sources[key].problem(warning, format, args...))
+	}
+
+	var registered []string
+	extensions, basenames := make(map[string]bool), make(map[string]bool)
+	for _, f := range FormatterRegistry {
+		registered = append(registered, f.Name())
+		for _, ext := range f.FileExtensions() {
+			if strings.HasPrefix(ext, ".") {
+				extensions[ext] = true
+			} else if !strings.ContainsAny(ext, "*?[") {
+				basenames[ext] = true
+			}
+		}
+	}
+
+	for key, names := range cfg.FormattersByExt {
+		source := sourceKey("formatters", key)
+		// keys that aren't extensions or patterns are file names, but a short
+		// lowercase name is most likely an extension without the dot
+		if isGlobKey(key) {
+			if _, err := path.Match(key, ""); err != nil {
+				add(source, false, "invalid glob pattern '%s'", key)
+			}
+		} else if !strings.HasPrefix(key, ".") && !basenames[key] {
+			if extensions["."+key] {
+				add(source, false, "'%s' only matches files named %s; extensions start with '.' (did you mean '.%s'?)", key, key, key)
+			} else if looksLikeExtension(key) {
+				add(source, false, "'%s' only matches files named %s; extensions start with '.'", key, key)
+			}
+		}
+		if len(names) == 0 {
+			add(source, false, "no formatters given for '%s'", key)
+		}
+		seen := make(map[string]bool)
+		for _, name := range names {
+			if name == NoFormatter {
+				if len(names) > 1 {
+					add(source, false, "'%s' can't be combined with other formatters", NoFormatter)
+				}
+			} else if LookupFormatter(name) == nil {
+				add(source, false, "unknown formatter '%s'%s", name, suggestion(name, registered))
+			} else if seen[name] {
+				add(source, false, "formatter %s listed multiple times for '%s'", name, key)
+			}
+			seen[name] = true
+		}
+	}
+
+	for i, pattern := range cfg.ExcludePatterns {
+		source := sourceKey("exclude", i)
+		if isAbsolutePattern(pattern, filepath.Dir(cfg.sources[source].file)) {
+			add(source, false, "exclude pattern '%s' is an absolute path; patterns are relative to the config's directory", pattern)
+		}
+	}
+
+	var enabled map[string]bool
+	if !nested {
+		enabled = cfg.enabledFormatters()
+	}
+	for name := range cfg.FormatterArgs {
+		source := sourceKey("formatter_args", name)
+		if LookupFormatter(name) == nil {
+			add(source, true, "formatter_args given for unknown formatter '%s'%s", name, suggestion(name, registered))
+		} else if enabled != nil && !enabled[name] {
+			if len(cfg.FormattersByExt) == 0 && !LookupFormatter(name).IsInstalled() {
+				add(source, true, "formatter_args given for %s, which isn't installed", name)
+			} else {
+				add(source, true, "formatter_args given for %s, which isn't enabled for any files", name)
+			}
+		}
+	}
+
+	if nested {
+		for key := range cfg.sources {
+			section := strings.Split(key, "/")[0]
+			switch section {
+			case "respect_gitignore", "case_insensitive_extensions", "custom_formatters", "plugins", "plugin_dir":
+				add(key, true, "%s isn't supported in nested configs and is ignored", section)
+			}
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i], problems[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return problems
+}
+
+// Like Validate(), but logs the warnings. Returns an error describing the
+// other problems, if there are any.
+func (cfg *Config) Check(nested bool) error {
+	var errs []string
+	for _, p := range cfg.Validate(nested) {
+		if p.Warning {
+			log.Printf("Warning: %v", p)
+		} else {
+			errs = append(errs, p.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package stylize
+
+// Helpers for working with line-based differences between a file's original
+// content and the output of its formatters.
+
+import (
+	"strings"
+
+	"github.com/justbuchanan/stylize/formatters"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// Splits content into lines, keeping the line endings. Unlike
+// difflib.SplitLines(), this doesn't add an extra line at the end.
+func splitLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Returns the operations that transform lines a into lines b.
+func diffOpCodes(a, b []string) []difflib.OpCode {
+	return difflib.NewMatcherWithJunk(a, b, false, nil).GetOpCodes()
+}
+
+// Returns true if the change described by op touches any of the line ranges.
+// Line numbers in the ranges refer to the original content.
+func changeOverlapsRanges(op difflib.OpCode, ranges []formatters.LineRange) bool {
+	// lines I1+1 through I2 are replaced or deleted. Insertions (where I1 ==
+	// I2) go between lines I1 and I1+1, so they overlap a range containing
+	// either one.
+	first, last := op.I1+1, op.I2
+	if op.I1 == op.I2 {
+		first, last = op.I1, op.I1+1
+	}
+
+	for _, r := range ranges {
+		if first <= r.End && last >= r.Start {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the formatted content with only the changes that overlap the given
+// line ranges of the original content applied.
+func filterChanges(original, formatted string, ranges []formatters.LineRange) string {
+	a := splitLines(original)
+	b := splitLines(formatted)
+
+	var out strings.Builder
+	for _, op := range diffOpCodes(a, b) {
+		if op.Tag == 'e' || !changeOverlapsRanges(op, ranges) {
+			out.WriteString(strings.Join(a[op.I1:op.I2], ""))
+		} else {
+			out.WriteString(strings.Join(b[op.J1:op.J2], ""))
+		}
+	}
+	return out.String()
+}
+
+// Converts line ranges in the original content to the corresponding line
+// ranges in the formatted content. Ranges that were deleted entirely are
+// dropped.
+func mapRanges(original, formatted string, ranges []formatters.LineRange) []formatters.LineRange {
+	ops := diffOpCodes(splitLines(original), splitLines(formatted))
+
+	// maps a 0-based line index in a to one in b
+	mapLine := func(i int, isEnd bool) int {
+		for _, op := range ops {
+			if i < op.I1 || i >= op.I2 {
+				continue
+			}
+			if op.Tag == 'e' {
+				return op.J1 + i - op.I1
+			} else if isEnd {
+				return op.J2 - 1
+			}
+			return op.J1
+		}
+		// past the end of the file
+		return i
+	}
+
+	var mapped []formatters.LineRange
+	for _, r := range ranges {
+		m := formatters.LineRange{
+			Start: mapLine(r.Start-1, false) + 1,
+			End:   mapLine(r.End-1, true) + 1,
+		}
+		if m.End >= m.Start {
+			mapped = append(mapped, m)
+		}
+	}
+	return mapped
+}
+
+// Returns groups of nearby changes with up to the given number of lines of
+// context around them, like the hunks of a unified diff.
+func groupedChanges(a, b []string, context int) [][]difflib.OpCode {
+	var groups [][]difflib.OpCode
+	for _, group := range difflib.NewMatcherWithJunk(a, b, false, nil).GetGroupedOpCodes(context) {
+		for _, op := range group {
+			if op.Tag != 'e' {
+				groups = append(groups, group)
+				break
+			}
+		}
+	}
+	return groups
+}
+
+// Returns the first and last lines of the original content touched by the
+// changes in a group, excluding context. Pure insertions are attributed to the
+// line they're inserted before, or the last line if inserted at the end.
+// @param numLines number of lines in the original content
+func changedLineSpan(group []difflib.OpCode, numLines int) (int, int) {
+	first, last := -1, -1
+	for _, op := range group {
+		if op.Tag == 'e' {
+			continue
+		}
+		if first < 0 {
+			first = op.I1 + 1
+		}
+		last = op.I2
+	}
+
+	if first > numLines && numLines > 0 {
+		first = numLines
+	}
+	if last < first {
+		last = first
+	}
+	return first, last
+}
+
+// Returns the number of lines added and removed by changing a to b.
+func countChangedLines(a, b []byte) (int, int) {
+	added, removed := 0, 0
+	for _, op := range diffOpCodes(splitLines(string(a)), splitLines(string(b))) {
+		if op.Tag != 'e' {
+			added += op.J2 - op.J1
+			removed += op.I2 - op.I1
+		}
+	}
+	return added, removed
+}
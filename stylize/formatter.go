@@ -0,0 +1,336 @@
+package stylize
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+
+	"github.com/justbuchanan/stylize/formatters"
+	"github.com/pkg/errors"
+)
+
+// Common interface for all formatters.
+//
+// A new formatter can be added by implementing this interface and adding it to
+// the global registry.
+type Formatter interface {
+	Name() string
+	// Reads the input stream and writes a prettified version to the output.
+	FormatToBuffer(args []string, file string, in io.Reader, out io.Writer) error
+	// Reformats the given file in-place. Stylize itself formats files in place
+	// using FormatToBuffer(), so this is only for use by other tools.
+	FormatInPlace(args []string, file string) error
+	// Check if the required binary is installed.
+	IsInstalled() bool
+	// A list of file extensions (including the '.') that this formatter applies to.
+	FileExtensions() []string
+}
+
+// Formatters can optionally implement this interface to report the version of
+// the underlying tool. Results are only cached for formatters that do, since
+// upgrading a tool can change its output.
+type VersionedFormatter interface {
+	Version() (string, error)
+}
+
+// Formatters can optionally implement this interface to list the names of
+// style config files (such as .clang-format) that affect their output. These
+// are looked up in the directories containing the formatted file.
+type StyleConfigFormatter interface {
+	StyleConfigFiles() []string
+}
+
+// Formatters can optionally implement this interface if the underlying tool
+// can restrict formatting to certain lines of a file.
+type RangeFormatter interface {
+	FormatRangesToBuffer(args []string, file string, ranges []formatters.LineRange, in io.Reader, out io.Writer) error
+}
+
+// Formatters can optionally implement this interface if the underlying tool
+// can process many files in a single invocation. This avoids paying the tool's
+// startup cost for every file.
+type BatchFormatter interface {
+	// Returns the subset of the given files that need formatting.
+	ListUnformatted(args []string, absPaths []string) ([]string, error)
+}
+
+// Formatters can optionally implement this interface if the underlying tool
+// can run as a long-lived server. When workers are enabled, files are sent to a
+// pool of running workers instead of starting the tool for each file.
+type WorkerFormatter interface {
+	StartWorker() (formatters.Worker, error)
+}
+
+// Formatters can optionally implement this interface to report problems that
+// they found, but didn't fix, such as lines that are too long. Diagnostics are
+// included in the results, logs, and reports.
+type DiagnosticFormatter interface {
+	FormatWithDiagnostics(args []string, file string, in io.Reader, out io.Writer) ([]formatters.Diagnostic, error)
+}
+
+// A problem that a formatter reported for a file, but didn't fix.
+type Diagnostic struct {
+	// Name of the formatter that reported it
+	Formatter string
+	// Line number starting at 1, or 0 if the diagnostic applies to the whole
+	// file. Lines refer to the content given to the formatter, which may have
+	// been changed by earlier formatters in the chain.
+	Line    int
+	Message string
+}
+
+// Returns a description of the diagnostic like "main.py:3: Line too long
+// (pylint)".
+// @param file path of the file that the diagnostic is for
+func (d Diagnostic) describe(file string) string {
+	if d.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s (%s)", file, d.Line, d.Message, d.Formatter)
+	}
+	return fmt.Sprintf("%s: %s (%s)", file, d.Message, d.Formatter)
+}
+
+// Runs a single formatter, returning its diagnostics if it reports any.
+func formatWithDiagnostics(F Formatter, args []string, file string, in io.Reader, out io.Writer) ([]Diagnostic, error) {
+	df, ok := F.(DiagnosticFormatter)
+	if !ok {
+		return nil, F.FormatToBuffer(args, file, in, out)
+	}
+	found, err := df.FormatWithDiagnostics(args, file, in, out)
+	var diagnostics []Diagnostic
+	for _, d := range found {
+		diagnostics = append(diagnostics, Diagnostic{Formatter: F.Name(), Line: d.Line, Message: d.Message})
+	}
+	return diagnostics, err
+}
+
+// Runs the content through each formatter in the chain in order, feeding the
+// output of one formatter into the next.
+// @param formatterArgs formatter arguments keyed by formatter name
+func FormatWithChain(chain []Formatter, formatterArgs map[string][]string, file string, content []byte) ([]byte, error) {
+	formatted, _, err := formatWithChain(chain, formatterArgs, file, content)
+	return formatted, err
+}
+
+// Like FormatWithChain(), but also returns the diagnostics reported by the
+// formatters.
+func formatWithChain(chain []Formatter, formatterArgs map[string][]string, file string, content []byte) ([]byte, []Diagnostic, error) {
+	var diagnostics []Diagnostic
+	for _, F := range chain {
+		var formattedOutput bytes.Buffer
+		found, err := formatWithDiagnostics(F, formatterArgs[F.Name()], file, bytes.NewReader(content), &formattedOutput)
+		if err != nil {
+			return nil, nil, err
+		}
+		diagnostics = append(diagnostics, found...)
+		content = formattedOutput.Bytes()
+	}
+	return content, diagnostics, nil
+}
+
+// Like formatters.WriteFileAtomic(), but leaves the file alone and returns an
+// error if it no longer has the original content, so that edits made while it
+// was being formatted aren't lost.
+func replaceFileContent(absPath string, original, content []byte) error {
+	current, err := ioutil.ReadFile(absPath)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(current, original) {
+		return errors.Errorf("%s changed while it was being formatted, not overwriting it", absPath)
+	}
+	return formatters.WriteFileAtomic(absPath, content)
+}
+
+// Like FormatWithChain(), but only keeps changes that touch the given line
+// ranges of the original content. Formatters that implement RangeFormatter are
+// told about the ranges. For the others, changes outside of the ranges are
+// discarded.
+func FormatRangesWithChain(chain []Formatter, formatterArgs map[string][]string, file string, ranges []formatters.LineRange, content []byte) ([]byte, error) {
+	formatted, _, err := formatRangesWithChain(chain, formatterArgs, file, ranges, content)
+	return formatted, err
+}
+
+// Like FormatRangesWithChain(), but also returns the diagnostics reported by
+// the formatters for lines in the ranges.
+func formatRangesWithChain(chain []Formatter, formatterArgs map[string][]string, file string, ranges []formatters.LineRange, content []byte) ([]byte, []Diagnostic, error) {
+	var diagnostics []Diagnostic
+	for _, F := range chain {
+		if len(ranges) == 0 {
+			break
+		}
+
+		var formattedOutput bytes.Buffer
+		var found []Diagnostic
+		var err error
+		if rf, ok := F.(RangeFormatter); ok {
+			err = rf.FormatRangesToBuffer(formatterArgs[F.Name()], file, ranges, bytes.NewReader(content), &formattedOutput)
+		} else {
+			found, err = formatWithDiagnostics(F, formatterArgs[F.Name()], file, bytes.NewReader(content), &formattedOutput)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, d := range found {
+			if d.Line == 0 || inRanges(d.Line, ranges) {
+				diagnostics = append(diagnostics, d)
+			}
+		}
+
+		// Range formatters may still touch nearby lines, so everything is
+		// filtered. Line numbers can shift, so the ranges are updated for the
+		// next formatter in the chain.
+		filtered := filterChanges(string(content), formattedOutput.String(), ranges)
+		ranges = mapRanges(string(content), filtered, ranges)
+		content = []byte(filtered)
+	}
+	return content, diagnostics, nil
+}
+
+// Returns true if the line is in one of the ranges.
+func inRanges(line int, ranges []formatters.LineRange) bool {
+	for _, r := range ranges {
+		if line >= r.Start && line <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+func CreatePatchWithFormatter(F Formatter, args []string, wdir, file string) (string, error) {
+	return CreatePatchWithChain([]Formatter{F}, map[string][]string{F.Name(): args}, wdir, file)
+}
+
+func CreatePatchWithChain(chain []Formatter, formatterArgs map[string][]string, wdir, file string) (string, error) {
+	fileContent, err := ioutil.ReadFile(filepath.Join(wdir, file))
+	if err != nil {
+		return "", err
+	}
+
+	// formatters get the absolute path, like when running normally
+	formatted, err := FormatWithChain(chain, formatterArgs, filepath.Join(wdir, file), fileContent)
+	if err != nil {
+		return "", err
+	}
+
+	return createPatch(file, fileContent, formatted), nil
+}
+
+func LookupFormatter(name string) Formatter {
+	for _, f := range FormatterRegistry {
+		if f.Name() == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Adds a formatter to the global registry. If a formatter with the same name
+// is already registered, it is replaced and keeps its precedence. Otherwise the
+// new formatter is appended, giving it the lowest priority.
+func RegisterFormatter(f Formatter) {
+	for i, existing := range FormatterRegistry {
+		if existing.Name() == f.Name() {
+			FormatterRegistry[i] = f
+			return
+		}
+	}
+	FormatterRegistry = append(FormatterRegistry, f)
+}
+
+// Stops all registered plugin formatters. Plugins are restarted if they're
+// used again.
+func ShutdownPlugins() {
+	for _, f := range FormatterRegistry {
+		if p, ok := f.(*formatters.PluginFormatter); ok {
+			if err := p.Shutdown(); err != nil {
+				log.Printf("Error shutting down plugin %s: %v", p.Name(), err)
+			}
+		}
+	}
+}
+
+// Returns a map of file extension to formatter chain for the ones specied in
+// the input mapping. Keys mapped to NoFormatter get an empty chain.
+func LoadFormattersFromMapping(extToNames map[string]FormatterList) (map[string][]Formatter, error) {
+	byExt := make(map[string][]Formatter)
+	for ext, names := range extToNames {
+		if len(names) == 0 {
+			return nil, errors.Errorf("No formatters given for extension '%s'", ext)
+		}
+		if len(names) == 1 && names[0] == NoFormatter {
+			byExt[ext] = []Formatter{}
+			continue
+		}
+		for _, name := range names {
+			if name == NoFormatter {
+				return nil, errors.Errorf("'%s' can't be combined with other formatters for '%s'", NoFormatter, ext)
+			}
+			formatter := LookupFormatter(name)
+			if formatter == nil {
+				return nil, errors.Errorf("Unknown formatter: %s", name)
+			}
+			if !formatter.IsInstalled() {
+				return nil, errors.Errorf("Formatter %s not installed", name)
+			}
+			for _, existing := range byExt[ext] {
+				if existing == formatter {
+					return nil, errors.Errorf("Formatter %s listed multiple times for extension '%s'", name, ext)
+				}
+			}
+			byExt[ext] = append(byExt[ext], formatter)
+		}
+	}
+
+	return byExt, nil
+}
+
+// Returns the names of the formatters in the chain.
+func ChainNames(chain []Formatter) []string {
+	var names []string
+	for _, f := range chain {
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+// Returns a map of file extension to formatter for all installed formatters in
+// the registry. Each extension gets a chain containing a single formatter.
+func LoadDefaultFormatters() map[string][]Formatter {
+	byExt := make(map[string][]Formatter)
+	for _, f := range FormatterRegistry {
+		if !f.IsInstalled() {
+			log.Printf("Skipping formatter %s b/c it's not installed", f.Name())
+			continue
+		}
+
+		for _, ext := range f.FileExtensions() {
+			if byExt[ext] != nil {
+				// log.Printf("Multiple formatters for extension '%s'", ext)
+				continue
+			}
+
+			byExt[ext] = []Formatter{f}
+		}
+	}
+
+	return byExt
+}
+
+var (
+	// Global list of all formatters.
+	// If multiple formatters apply to the same file type, their order here
+	// determines precedence. Lower index = higher priority.
+	FormatterRegistry = []Formatter{
+		&formatters.ClangFormatter{},
+		&formatters.UncrustifyFormatter{},
+		&formatters.PrettierFormatter{},
+		&formatters.YapfFormatter{},
+		&formatters.BlackFormatter{},
+		&formatters.GofmtFormatter{},
+		&formatters.BuildifierFormatter{},
+		&formatters.RustfmtFormatter{},
+	}
+)
@@ -0,0 +1,243 @@
+package stylize
+
+// Exclude patterns follow the same rules as gitignore files (see
+// https://git-scm.com/docs/gitignore). Patterns can come from the exclude list
+// in the config/command-line or from ignore files (.stylizeignore and
+// optionally .gitignore) in any directory under the root. Patterns in an ignore
+// file or a nested config are relative to the directory containing it.
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Name of the per-directory file containing exclude patterns.
+const StylizeIgnoreFile = ".stylizeignore"
+
+type ignorePattern struct {
+	// Directory that the pattern is relative to, or "" for the root.
+	base string
+	// Pattern split into path segments. Patterns that aren't anchored start
+	// with a "**" segment.
+	segments []string
+	// Patterns starting with "!" re-include files excluded by earlier patterns.
+	negate bool
+	// Patterns ending in "/" only match directories.
+	dirOnly bool
+}
+
+// Parses a single line of a gitignore-style file. Returns false for blank lines
+// and comments.
+func parseIgnorePattern(base, line string) (ignorePattern, bool) {
+	p := ignorePattern{base: base}
+
+	// Trailing spaces are ignored unless escaped with a backslash
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if len(line) == 0 || strings.HasPrefix(line, "#") {
+		return p, false
+	}
+
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if len(line) == 0 {
+		return p, false
+	}
+
+	// A slash at the beginning or middle of the pattern anchors it to the
+	// base directory. Otherwise it can match at any depth.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	p.segments = strings.Split(line, "/")
+	if !anchored {
+		p.segments = append([]string{"**"}, p.segments...)
+	}
+
+	return p, true
+}
+
+// Returns true if the path segments match the pattern segments. A "**" segment
+// matches zero or more path segments.
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// trailing "**" matches everything inside
+			if len(pattern) == 1 {
+				return len(segments) > 0
+			}
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+		if matched, err := path.Match(pattern[0], segments[0]); err != nil || !matched {
+			return false
+		}
+		pattern = pattern[1:]
+		segments = segments[1:]
+	}
+
+	return len(segments) == 0
+}
+
+// Returns true if the pattern applies to the given path, which uses forward
+// slashes and is relative to the root.
+func (p *ignorePattern) matches(relPath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	if len(p.base) > 0 {
+		if !strings.HasPrefix(relPath, p.base+"/") {
+			return false
+		}
+		relPath = relPath[len(p.base)+1:]
+	}
+
+	return matchSegments(p.segments, strings.Split(relPath, "/"))
+}
+
+// Decides which files are excluded. Ignore files are read lazily as
+// directories are visited, so it's cheap to construct for a large tree.
+type Excluder struct {
+	rootDir string
+	// Patterns from the exclude list, which apply relative to the root.
+	patterns []ignorePattern
+	// Names of ignore files to read in each directory.
+	ignoreFiles []string
+	// Optional configs whose exclude patterns apply relative to their
+	// directories.
+	configs *ConfigTree
+
+	mutex sync.Mutex
+	// Patterns from ignore files keyed by directory relative to the root.
+	byDir map[string][]ignorePattern
+}
+
+// @param rootDir absolute path that files are relative to
+// @param exclude gitignore-style patterns relative to rootDir
+// @param ignoreFiles names of files to read additional patterns from in each
+// directory, such as ".stylizeignore".
+func NewExcluder(rootDir string, exclude []string, ignoreFiles []string) *Excluder {
+	e := &Excluder{
+		rootDir:     rootDir,
+		ignoreFiles: ignoreFiles,
+		byDir:       make(map[string][]ignorePattern),
+	}
+	for _, line := range exclude {
+		if p, ok := parseIgnorePattern("", line); ok {
+			e.patterns = append(e.patterns, p)
+		}
+	}
+	return e
+}
+
+// Returns the patterns from ignore files in the given directory.
+// @param dir directory relative to the root, using forward slashes. The root
+// itself is "".
+func (e *Excluder) dirPatterns(dir string) []ignorePattern {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	patterns, ok := e.byDir[dir]
+	if ok {
+		return patterns
+	}
+
+	if e.configs != nil {
+		for _, line := range e.configs.excludePatterns(dir) {
+			if p, ok := parseIgnorePattern(dir, line); ok {
+				patterns = append(patterns, p)
+			}
+		}
+	}
+	for _, name := range e.ignoreFiles {
+		f, err := os.Open(filepath.Join(e.rootDir, filepath.FromSlash(dir), name))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if p, ok := parseIgnorePattern(dir, scanner.Text()); ok {
+				patterns = append(patterns, p)
+			}
+		}
+		f.Close()
+	}
+
+	e.byDir[dir] = patterns
+	return patterns
+}
+
+// Checks only the patterns that apply to the path itself, ignoring whether its
+// parent directories are excluded. Later patterns take precedence over earlier
+// ones and ignore files in deeper directories take precedence over ones higher
+// up.
+func (e *Excluder) matches(relPath string, isDir bool) bool {
+	excluded := false
+	check := func(patterns []ignorePattern) {
+		for i := range patterns {
+			if patterns[i].matches(relPath, isDir) {
+				excluded = !patterns[i].negate
+			}
+		}
+	}
+
+	check(e.patterns)
+	if len(e.ignoreFiles) > 0 || e.configs != nil {
+		check(e.dirPatterns(""))
+		for i, c := range relPath {
+			if c == '/' {
+				check(e.dirPatterns(relPath[:i]))
+			}
+		}
+	}
+
+	return excluded
+}
+
+// Returns true if the file or directory at the given path is excluded. As with
+// git, a file can't be re-included if one of its parent directories is
+// excluded.
+// @param relPath path relative to the root
+func (e *Excluder) IsExcluded(relPath string, isDir bool) bool {
+	relPath = filepath.ToSlash(relPath)
+	if relPath == "." || len(relPath) == 0 {
+		return false
+	}
+
+	for i, c := range relPath {
+		if c == '/' && e.matches(relPath[:i], true) {
+			return true
+		}
+	}
+	return e.matches(relPath, isDir)
+}
+
+// Returns an excluder for the context's exclude patterns, ignore files, and
+// nested configs.
+func (ctx *StylizeContext) newExcluder() *Excluder {
+	e := NewExcluder(ctx.RootDir, ctx.Exclude, ctx.IgnoreFiles)
+	e.configs = ctx.Configs
+	return e
+}
@@ -0,0 +1,286 @@
+package stylize
+
+// Suggests a starter config from the contents of a repository. Files are
+// counted by extension and matched against FormatterRegistry, preferring
+// formatters that the repo already has style files for.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Directories that usually contain vendored or generated code, which are
+// suggested as excludes.
+var vendorDirNames = []string{
+	"vendor", "third_party", "third-party", "node_modules", "build", "dist", "out", "target",
+}
+
+// Style files that are shared by several tools. They only indicate that a
+// formatter is used if they contain its section, keyed by formatter name.
+// Files without an entry for a formatter are ignored for it.
+var sharedStyleFiles = map[string]map[string]string{
+	"pyproject.toml": {"black": "[tool.black]", "yapf": "[tool.yapf]"},
+	"setup.cfg":      {"yapf": "[yapf]"},
+	"package.json":   {"prettier": `"prettier"`},
+	".editorconfig":  {},
+}
+
+// Summary of a repository's contents used to suggest a config.
+type RepoSurvey struct {
+	// Number of files keyed by extension, or by name for files without one
+	FileCounts map[string]int
+	// Style files found for each formatter, keyed by formatter name. Paths are
+	// relative to the root.
+	StyleFiles map[string][]string
+	// Patterns for directories that look like vendored or generated code
+	SuggestedExcludes []string
+	// True if the repo has .gitignore files, which were respected.
+	HasGitignore bool
+}
+
+// Returns true if the file is one of F's style files.
+// @param absPath path of the file, which is read if it's shared with other
+// tools
+func isStyleFile(F Formatter, absPath string) bool {
+	sf, ok := F.(StyleConfigFormatter)
+	if !ok {
+		return false
+	}
+	name := filepath.Base(absPath)
+	for _, styleFile := range sf.StyleConfigFiles() {
+		if styleFile != name {
+			continue
+		}
+		markers, shared := sharedStyleFiles[name]
+		if !shared {
+			return true
+		}
+		marker, ok := markers[F.Name()]
+		if !ok {
+			return false
+		}
+		content, err := ioutil.ReadFile(absPath)
+		return err == nil && bytes.Contains(content, []byte(marker))
+	}
+	return false
+}
+
+// Walks the tree under rootDir and counts files by extension. Directories that
+// look like vendored code are skipped and suggested as excludes.
+// @param excluder files that shouldn't be counted. Ignore files named
+// .gitignore are noted in the result.
+func SurveyRepo(rootDir string, excluder *Excluder) (*RepoSurvey, error) {
+	survey := &RepoSurvey{
+		FileCounts: make(map[string]int),
+		StyleFiles: make(map[string][]string),
+	}
+	suggested := make(map[string]bool)
+
+	err := filepath.Walk(rootDir, func(path string, fi os.FileInfo, err error) error {
+		// unreadable files and directories are left out of the survey rather
+		// than stopping it
+		if err != nil && path != rootDir {
+			log.Printf("Skipping %s: %v", path, err)
+			if fi != nil && fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		relPath, _ := filepath.Rel(rootDir, path)
+		if relPath == "." {
+			return nil
+		}
+		if excluder.IsExcluded(relPath, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if fi.IsDir() {
+			for _, name := range vendorDirNames {
+				if fi.Name() == name {
+					suggested[name+"/"] = true
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		if fi.Name() == ".gitignore" {
+			survey.HasGitignore = true
+		}
+		for _, F := range FormatterRegistry {
+			if isStyleFile(F, path) {
+				survey.StyleFiles[F.Name()] = append(survey.StyleFiles[F.Name()], filepath.ToSlash(relPath))
+			}
+		}
+
+		// dotfiles like .gitignore are settings rather than code
+		ext := filepath.Ext(fi.Name())
+		if ext == fi.Name() {
+			return nil
+		} else if len(ext) == 0 {
+			ext = fi.Name()
+		}
+		survey.FileCounts[ext]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for pattern := range suggested {
+		survey.SuggestedExcludes = append(survey.SuggestedExcludes, pattern)
+	}
+	sort.Strings(survey.SuggestedExcludes)
+	return survey, nil
+}
+
+// A formatter chosen for an extension
+type formatterChoice struct {
+	ext   string
+	count int
+	// nil if no formatter handles the extension
+	formatter Formatter
+	// Style files that the formatter was chosen for, if any
+	styleFiles []string
+}
+
+// Picks a formatter for each extension in the survey. Installed formatters
+// with style files in the repo are preferred, then other installed formatters
+// in registry order. If none are installed, the choice is still made so that
+// it can be suggested.
+func (s *RepoSurvey) chooseFormatters() []formatterChoice {
+	var choices []formatterChoice
+	for ext, count := range s.FileCounts {
+		var candidates []Formatter
+		for _, F := range FormatterRegistry {
+			for _, e := range F.FileExtensions() {
+				if e == ext {
+					candidates = append(candidates, F)
+					break
+				}
+			}
+		}
+
+		choice := formatterChoice{ext: ext, count: count}
+		rank := func(F Formatter) int {
+			r := 0
+			if F.IsInstalled() {
+				r += 2
+			}
+			if len(s.StyleFiles[F.Name()]) > 0 {
+				r++
+			}
+			return r
+		}
+		best := -1
+		for _, F := range candidates {
+			if r := rank(F); r > best {
+				choice.formatter, best = F, r
+			}
+		}
+		if choice.formatter != nil {
+			choice.styleFiles = s.StyleFiles[choice.formatter.Name()]
+		}
+		choices = append(choices, choice)
+	}
+
+	// most common first
+	sort.Slice(choices, func(i, j int) bool {
+		if choices[i].count != choices[j].count {
+			return choices[i].count > choices[j].count
+		}
+		return choices[i].ext < choices[j].ext
+	})
+	return choices
+}
+
+func pluralFiles(n int) string {
+	if n == 1 {
+		return "1 file"
+	}
+	return fmt.Sprintf("%d files", n)
+}
+
+// Writes a commented config based on the survey. Formatters that aren't
+// installed are included, but commented out, so that the config can be used
+// right away.
+func (s *RepoSurvey) WriteConfig(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# Generated by `stylize init`. Run `stylize config validate` after editing.\n")
+	b.WriteString("#\n")
+	b.WriteString("# Formatters to run keyed by file extension. Run `stylize --print_formatters`\n")
+	b.WriteString("# to see the supported formatters.\n")
+
+	var uncovered []formatterChoice
+	var lines []string
+	for _, c := range s.chooseFormatters() {
+		if c.formatter == nil {
+			// Files without an extension are only reported if a formatter
+			// handles them by name.
+			if strings.HasPrefix(c.ext, ".") && len(c.ext) > 1 {
+				uncovered = append(uncovered, c)
+			}
+			continue
+		}
+		comment := pluralFiles(c.count)
+		if n := len(c.styleFiles); n > 3 {
+			comment += fmt.Sprintf(", style from %s and %d more", strings.Join(c.styleFiles[:3], ", "), n-3)
+		} else if n > 0 {
+			comment += ", style from " + strings.Join(c.styleFiles, ", ")
+		}
+		line := fmt.Sprintf("%s: %s  # %s", yamlScalar(c.ext), c.formatter.Name(), comment)
+		if !c.formatter.IsInstalled() {
+			line = fmt.Sprintf("# %s; %s isn't installed", line, c.formatter.Name())
+		}
+		lines = append(lines, "  "+line)
+	}
+	if len(lines) > 0 {
+		b.WriteString("formatters:\n")
+		for _, line := range lines {
+			b.WriteString(line + "\n")
+		}
+	} else {
+		b.WriteString("# formatters: {}\n")
+	}
+
+	b.WriteString("\n# Patterns for files to skip, which follow the same rules as .gitignore files.\n")
+	if len(s.SuggestedExcludes) > 0 {
+		b.WriteString("exclude:\n")
+		for _, pattern := range s.SuggestedExcludes {
+			b.WriteString("  - " + yamlScalar(pattern) + "\n")
+		}
+	} else {
+		b.WriteString("# exclude: [vendor/]\n")
+	}
+
+	if s.HasGitignore {
+		b.WriteString("\n# Skip files ignored by .gitignore files.\n")
+		b.WriteString("respect_gitignore: true\n")
+	}
+
+	if len(uncovered) > 0 {
+		b.WriteString("\n# No formatter handles these extensions. Formatters for them can be added with\n")
+		b.WriteString("# custom_formatters or plugins.\n")
+		for _, c := range uncovered {
+			fmt.Fprintf(&b, "#   %s (%s)\n", c.ext, pluralFiles(c.count))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
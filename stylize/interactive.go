@@ -0,0 +1,131 @@
+package stylize
+
+// Interactive review of formatting changes, modeled on `git add -p`. Each hunk
+// of a file's patch is shown to the user and only the accepted hunks are
+// written back to the file.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const reviewHelp = `y - apply this hunk
+n - don't apply this hunk
+a - apply this hunk and all later hunks in the file
+q - quit; don't apply this hunk or any of the remaining ones
+? - print help
+`
+
+// Asks the user which hunks of each change to keep.
+type HunkReviewer struct {
+	in  *bufio.Reader
+	out io.Writer
+	// Set once the user quits. No more questions are asked after that.
+	quit bool
+}
+
+// @param in where answers are read from, usually a terminal
+// @param out where hunks and prompts are written to
+func NewHunkReviewer(in io.Reader, out io.Writer) *HunkReviewer {
+	return &HunkReviewer{in: bufio.NewReader(in), out: out}
+}
+
+// Reads an answer to a prompt. Returns "q" at the end of the input so that an
+// interrupted review doesn't apply anything else.
+func (R *HunkReviewer) answer(prompt string) string {
+	for {
+		fmt.Fprint(R.out, prompt)
+		line, err := R.in.ReadString('\n')
+		if err != nil && len(line) == 0 {
+			fmt.Fprintln(R.out)
+			return "q"
+		}
+		switch answer := strings.ToLower(strings.TrimSpace(line)); answer {
+		case "y", "n", "a", "q":
+			return answer
+		default:
+			fmt.Fprint(R.out, reviewHelp)
+		}
+	}
+}
+
+// Shows each hunk of the change from original to formatted and asks whether to
+// apply it. Returns the original content with only the accepted hunks applied
+// and the number of hunks that were accepted.
+func (R *HunkReviewer) Review(file string, original, formatted []byte) ([]byte, int, error) {
+	if R.quit {
+		return original, 0, nil
+	}
+	patches, err := ParsePatch(strings.NewReader(createPatch(file, original, formatted)))
+	if err != nil || len(patches) == 0 {
+		return original, 0, err
+	}
+
+	hunks := patches[0].Hunks
+	var accepted []PatchHunk
+	acceptRest := false
+	for i, hunk := range hunks {
+		if acceptRest {
+			accepted = append(accepted, hunk)
+			continue
+		}
+
+		fmt.Fprintf(R.out, "%s\n%s", filepath.ToSlash(file), hunk.Text)
+		switch R.answer(fmt.Sprintf("(%d/%d) Apply this hunk [y,n,a,q,?]? ", i+1, len(hunks))) {
+		case "y":
+			accepted = append(accepted, hunk)
+		case "a":
+			accepted = append(accepted, hunk)
+			acceptRest = true
+		case "q":
+			R.quit = true
+		}
+		if R.quit {
+			break
+		}
+	}
+
+	if len(accepted) == 0 {
+		return original, 0, nil
+	}
+	// the hunks come from the same content, so they always apply
+	lines, _ := applyHunks(splitLines(string(original)), accepted)
+	return []byte(strings.Join(lines, "")), len(accepted), nil
+}
+
+// Asks the reviewer about each file that needs formatting and writes the
+// accepted changes. Files are reviewed one at a time, in the order they finish
+// formatting. Files without accepted changes are reported as not needing
+// formatting.
+func (ctx *StylizeContext) reviewChanges(results <-chan FormattingResult) <-chan FormattingResult {
+	resultsOut := make(chan FormattingResult)
+
+	go func() {
+		defer close(resultsOut)
+
+		for r := range results {
+			if r.Error == nil && r.FormatNeeded {
+				var content []byte
+				var accepted int
+				content, accepted, r.Error = ctx.Reviewer.Review(r.FilePath, r.Original, r.Formatted)
+				if r.Error == nil && accepted == 0 {
+					r.FormatNeeded = false
+				} else if r.Error == nil {
+					r.Formatted = content
+					if ctx.Staged {
+						r.Error = ctx.writeStagedContent(r.FilePath, r.Original, content)
+					} else {
+						// the user may have edited the file while answering
+						r.Error = replaceFileContent(filepath.Join(ctx.RootDir, r.FilePath), r.Original, content)
+					}
+				}
+			}
+			resultsOut <- r
+		}
+	}()
+
+	return resultsOut
+}
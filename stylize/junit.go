@@ -0,0 +1,104 @@
+package stylize
+
+// Writes reports in the JUnit XML format, which most CI systems can display as
+// test results. Each checked file is a test case.
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// Writes one test case per checked file. Files that need formatting have a
+// failure containing the patch and files that couldn't be formatted have an
+// error containing the formatter's output. Diagnostics from the formatters are
+// written to the test case's output.
+type JUnitReporter struct{}
+
+func (R *JUnitReporter) WriteReport(results []FormattingResult, out io.Writer) error {
+	suite := junitTestSuite{Name: "stylize"}
+	for _, r := range results {
+		tc := junitTestCase{
+			Name:      r.FilePath,
+			ClassName: "stylize." + strings.Join(r.Formatters, "+"),
+		}
+
+		if r.Error != nil {
+			suite.Errors++
+			tc.Error = &junitProblem{
+				Message: "Error running formatter",
+				Type:    "error",
+				Text:    r.Error.Error(),
+			}
+		} else if r.FormatNeeded {
+			suite.Failures++
+			patch := r.Patch
+			if len(patch) == 0 {
+				patch = createPatch(r.FilePath, r.Original, r.Formatted)
+			}
+			tc.Failure = &junitProblem{
+				Message: "File needs formatting",
+				Type:    "formatting",
+				Text:    patch,
+			}
+		}
+		for _, d := range r.Diagnostics {
+			tc.SystemOut += d.describe(r.FilePath) + "\n"
+		}
+
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	suite.Tests = len(suite.TestCases)
+
+	report := junitTestSuites{
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Errors:   suite.Errors,
+		Suites:   []junitTestSuite{suite},
+	}
+	return writeXML(report, out)
+}
+
+// Writes an XML document with a header.
+func writeXML(v interface{}, out io.Writer) error {
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(out)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(out, "\n")
+	return err
+}
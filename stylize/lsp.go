@@ -0,0 +1,413 @@
+package stylize
+
+// A minimal Language Server Protocol server that exposes the configured
+// formatters to editors. It supports whole-document and range formatting and
+// publishes "needs formatting" diagnostics when documents are saved. See
+// https://microsoft.github.io/language-server-protocol/specification
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/justbuchanan/stylize/formatters"
+	"github.com/pkg/errors"
+)
+
+// JSON-RPC error codes
+const (
+	lspInvalidRequest = -32600
+	lspMethodNotFound = -32601
+	lspInvalidParams  = -32602
+	lspInternalError  = -32603
+)
+
+// Diagnostic severities
+const (
+	lspSeverityError   = 1
+	lspSeverityWarning = 2
+)
+
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type lspDocumentParams struct {
+	TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+	// Only set for range formatting
+	Range *lspRange `json:"range"`
+}
+
+type lspDidOpenParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+}
+
+type lspDidChangeParams struct {
+	TextDocument   lspTextDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type lspDidSaveParams struct {
+	TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+	Text         *string                   `json:"text"`
+}
+
+type lspServer struct {
+	ctx *StylizeContext
+	in  *bufio.Reader
+	out io.Writer
+
+	writeMutex sync.Mutex
+	// Content of open documents keyed by uri
+	documents map[string]string
+	// Set once the client has sent a shutdown request
+	shutdown bool
+}
+
+// Returned by ServeLSP() when the client sends the exit notification without a
+// shutdown request first, in which case the server should exit with code 1.
+var ErrExitWithoutShutdown = errors.New("exit notification received without a shutdown request")
+
+// Serves LSP requests read from in and writes responses to out until the client
+// sends the exit notification or the input is closed. Documents are formatted
+// using the formatters and arguments configured in ctx.
+func ServeLSP(ctx *StylizeContext, in io.Reader, out io.Writer) error {
+	s := &lspServer{
+		ctx:       ctx,
+		in:        bufio.NewReader(in),
+		out:       out,
+		documents: make(map[string]string),
+	}
+	defer ctx.CloseWorkers()
+
+	for {
+		msg, err := s.readMessage()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return ErrExitWithoutShutdown
+			}
+			return nil
+		}
+
+		result, rpcErr := s.handle(msg)
+		// notifications don't get responses
+		if msg.ID == nil {
+			continue
+		}
+		response := lspMessage{JSONRPC: "2.0", ID: msg.ID, Result: result, Error: rpcErr}
+		if rpcErr == nil && result == nil {
+			// The result must be present (as null) for successful responses
+			response.Result = json.RawMessage("null")
+		}
+		if err = s.writeMessage(response); err != nil {
+			return err
+		}
+	}
+}
+
+// Reads a single message, which consists of headers followed by a JSON body.
+func (s *lspServer) readMessage() (*lspMessage, error) {
+	headers, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid Content-Length header")
+	}
+	body := make([]byte, length)
+	if _, err = io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+
+	var msg lspMessage
+	if err = json.Unmarshal(body, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (s *lspServer) writeMessage(msg lspMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	if _, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = s.out.Write(body)
+	return err
+}
+
+func (s *lspServer) notify(method string, params interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return s.writeMessage(lspMessage{JSONRPC: "2.0", Method: method, Params: raw})
+}
+
+func (s *lspServer) handle(msg *lspMessage) (interface{}, *lspError) {
+	invalidParams := func(err error) *lspError {
+		return &lspError{Code: lspInvalidParams, Message: err.Error()}
+	}
+
+	if s.shutdown {
+		if msg.ID != nil {
+			return nil, &lspError{Code: lspInvalidRequest, Message: "Server is shutting down"}
+		}
+		return nil, nil
+	}
+
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": map[string]interface{}{
+					"openClose": true,
+					// full document sync
+					"change": 1,
+					"save":   map[string]interface{}{"includeText": true},
+				},
+				"documentFormattingProvider":      true,
+				"documentRangeFormattingProvider": true,
+			},
+			"serverInfo": map[string]interface{}{"name": "stylize"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params lspDidOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		s.documents[params.TextDocument.URI] = params.TextDocument.Text
+	case "textDocument/didChange":
+		var params lspDidChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.documents[params.TextDocument.URI] = params.ContentChanges[n-1].Text
+		}
+	case "textDocument/didClose":
+		var params lspDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(s.documents, params.TextDocument.URI)
+		s.publishDiagnostics(params.TextDocument.URI, []lspDiagnostic{})
+	case "textDocument/didSave":
+		var params lspDidSaveParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if params.Text != nil {
+			s.documents[params.TextDocument.URI] = *params.Text
+		}
+		s.publishDiagnostics(params.TextDocument.URI, s.diagnostics(params.TextDocument.URI))
+	case "textDocument/formatting", "textDocument/rangeFormatting":
+		var params lspDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		edits, err := s.format(params.TextDocument.URI, params.Range)
+		if err != nil {
+			return nil, &lspError{Code: lspInternalError, Message: err.Error()}
+		}
+		return edits, nil
+	default:
+		// unknown notifications are ignored
+		if msg.ID != nil {
+			return nil, &lspError{Code: lspMethodNotFound, Message: "Method not found: " + msg.Method}
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *lspServer) publishDiagnostics(uri string, diagnostics []lspDiagnostic) {
+	s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri":         uri,
+		"diagnostics": diagnostics,
+	})
+}
+
+// Returns the path used to pick formatters for a document. Files under the
+// root directory use paths relative to it so that exclude patterns apply.
+// Returns false if the document is excluded.
+func (s *lspServer) documentPath(uri string) (string, bool, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", false, err
+	}
+	if u.Scheme != "file" {
+		return "", false, errors.Errorf("Unsupported uri scheme '%s'", u.Scheme)
+	}
+
+	path, excluded := s.ctx.resolvePath(filepath.FromSlash(u.Path))
+	return path, !excluded, nil
+}
+
+// Formats an open document. Returns the original and formatted content. If no
+// formatter applies, the formatted content is the same as the original.
+// @param lines if non-nil, only changes touching these lines are made
+func (s *lspServer) formatDocument(uri string, lines []formatters.LineRange) (string, string, []string, error) {
+	text, ok := s.documents[uri]
+	if !ok {
+		return "", "", nil, errors.Errorf("Document isn't open: %s", uri)
+	}
+
+	path, included, err := s.documentPath(uri)
+	if err != nil {
+		return "", "", nil, err
+	}
+	chain, formatterArgs, err := s.ctx.formattersForFile(path)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if !included || len(chain) == 0 {
+		return text, text, nil, nil
+	}
+
+	var formatted []byte
+	if lines != nil {
+		formatted, err = FormatRangesWithChain(chain, formatterArgs, s.ctx.absPath(path), lines, []byte(text))
+	} else {
+		formatted, err = FormatWithChain(chain, formatterArgs, s.ctx.absPath(path), []byte(text))
+	}
+	return text, string(formatted), ChainNames(chain), err
+}
+
+// Converts a line index into a position. Lines past the end of a file that
+// doesn't end in a newline are mapped to the end of the last line.
+func lspLinePosition(lines []string, line int) lspPosition {
+	if line < len(lines) || len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lspPosition{Line: line}
+	}
+	last := lines[len(lines)-1]
+	return lspPosition{Line: len(lines) - 1, Character: len(utf16.Encode([]rune(last)))}
+}
+
+func (s *lspServer) format(uri string, r *lspRange) ([]lspTextEdit, error) {
+	var lines []formatters.LineRange
+	if r != nil {
+		// a range ending at the start of a line doesn't include that line
+		end := r.End.Line
+		if r.End.Character == 0 && end > r.Start.Line {
+			end--
+		}
+		lines = []formatters.LineRange{{Start: r.Start.Line + 1, End: end + 1}}
+	}
+
+	original, formatted, _, err := s.formatDocument(uri, lines)
+	if err != nil {
+		return nil, err
+	}
+
+	a := splitLines(original)
+	b := splitLines(formatted)
+	edits := []lspTextEdit{}
+	for _, op := range diffOpCodes(a, b) {
+		if op.Tag == 'e' {
+			continue
+		}
+		edits = append(edits, lspTextEdit{
+			Range:   lspRange{Start: lspLinePosition(a, op.I1), End: lspLinePosition(a, op.I2)},
+			NewText: strings.Join(b[op.J1:op.J2], ""),
+		})
+	}
+	return edits, nil
+}
+
+func (s *lspServer) diagnostics(uri string) []lspDiagnostic {
+	diagnostics := []lspDiagnostic{}
+
+	original, formatted, names, err := s.formatDocument(uri, nil)
+	if err != nil {
+		return append(diagnostics, lspDiagnostic{
+			Severity: lspSeverityError,
+			Source:   "stylize",
+			Message:  fmt.Sprintf("Error running formatter: %s", err),
+		})
+	}
+
+	a := splitLines(original)
+	b := splitLines(formatted)
+	for _, group := range groupedChanges(a, b, 0) {
+		first, last := changedLineSpan(group, len(a))
+		diagnostics = append(diagnostics, lspDiagnostic{
+			Range: lspRange{
+				Start: lspPosition{Line: first - 1},
+				End:   lspLinePosition(a, last),
+			},
+			Severity: lspSeverityWarning,
+			Source:   "stylize",
+			Message:  fmt.Sprintf("Needs formatting with %s", strings.Join(names, ", ")),
+		})
+	}
+	return diagnostics
+}
@@ -0,0 +1,105 @@
+package stylize
+
+// Keys in the formatters section of a config can be extensions (".go"), file
+// names ("BUILD", "Dockerfile.prod"), or glob patterns ("*.pb.go",
+// "src/*.ts"). When several keys match a file, the most specific one wins:
+// file names beat everything else, then keys with more literal characters win.
+// So "*.pb.go" beats ".go", and ".d.ts" beats ".ts". Patterns containing a
+// slash match the path relative to the root and other keys match the file
+// name.
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Formatter name that can be used in place of a list of formatters to leave
+// matching files alone, such as `"*.pb.go": none`.
+const NoFormatter = "none"
+
+// Returns true if a key in the formatters section is a glob pattern.
+func isGlobKey(key string) bool {
+	return strings.ContainsAny(key, "*?[")
+}
+
+// Returns true if a key in the formatters section is an extension. Extensions
+// can contain more than one dot, like ".d.ts".
+func isExtensionKey(key string) bool {
+	return strings.HasPrefix(key, ".") && len(key) > 1 && !isGlobKey(key) && !strings.Contains(key, "/")
+}
+
+// Returns the number of characters in a glob pattern that only match
+// themselves. Character classes count as one.
+func literalCount(pattern string) int {
+	count := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?':
+		case '[':
+			if end := strings.IndexByte(pattern[i:], ']'); end > 0 {
+				i += end
+			}
+			count++
+		case '\\':
+			i++
+			count++
+		default:
+			count++
+		}
+	}
+	return count
+}
+
+// Returns how specific a key is if it matches the file, or -1 if it doesn't.
+// @param slashPath path of the file relative to the root, using forward slashes
+// @param ignoreCase if true, extensions match regardless of case
+func matchKey(key, slashPath string, ignoreCase bool) int {
+	name := path.Base(slashPath)
+	switch {
+	case isExtensionKey(key):
+		if strings.HasSuffix(name, key) || (ignoreCase && strings.HasSuffix(strings.ToLower(name), strings.ToLower(key))) {
+			return literalCount(key)
+		}
+	case isGlobKey(key):
+		subject := name
+		if strings.Contains(key, "/") {
+			subject = slashPath
+		}
+		if matched, _ := path.Match(key, subject); matched {
+			return literalCount(key)
+		}
+	default:
+		// exact file names and paths beat any pattern
+		if key == name || key == slashPath {
+			return len(slashPath) + 1
+		}
+	}
+	return -1
+}
+
+// Returns the chain for the most specific key in byExt that matches the file,
+// or nil if none match. An empty chain means that the file was opted out with
+// NoFormatter.
+// @param ignoreCase if true, extensions match regardless of case
+func lookupFormatters(byExt map[string][]Formatter, file string, ignoreCase bool) []Formatter {
+	slashPath := filepath.ToSlash(file)
+	best, bestScore := "", -1
+	for key := range byExt {
+		score := matchKey(key, slashPath, ignoreCase)
+		// ties go to patterns containing a slash, then to the first key in
+		// sorted order, so that results are consistent
+		better := score > bestScore
+		if score == bestScore && score >= 0 {
+			keyHasSlash, bestHasSlash := strings.Contains(key, "/"), strings.Contains(best, "/")
+			better = (keyHasSlash && !bestHasSlash) || (keyHasSlash == bestHasSlash && key < best)
+		}
+		if better {
+			best, bestScore = key, score
+		}
+	}
+	if bestScore < 0 {
+		return nil
+	}
+	return byExt[best]
+}
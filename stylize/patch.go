@@ -0,0 +1,366 @@
+package stylize
+
+// Writes patches in the format produced by `git diff`, which both `git apply`
+// and `patch -p1` accept, and applies them with ApplyPatch().
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/justbuchanan/stylize/formatters"
+	"github.com/pkg/errors"
+)
+
+const noNewlineMarker = "\\ No newline at end of file\n"
+
+// Quotes a path the way git does if it contains special characters.
+func quotePatchPath(path string) string {
+	needsQuotes := false
+	for i := 0; i < len(path); i++ {
+		if c := path[i]; c < 0x20 || c >= 0x7f || c == '"' || c == '\\' {
+			needsQuotes = true
+			break
+		}
+	}
+	if !needsQuotes {
+		return path
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\t':
+			b.WriteString("\\t")
+		case '\n':
+			b.WriteString("\\n")
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&b, "\\%03o", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Formats a line range for a hunk header. Empty ranges refer to the line
+// before them.
+func unifiedRange(start, stop int) string {
+	length := stop - start
+	if length == 1 {
+		return strconv.Itoa(start + 1)
+	}
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+func writePatchLines(b *strings.Builder, prefix byte, lines []string) {
+	for _, line := range lines {
+		b.WriteByte(prefix)
+		b.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			b.WriteString("\n" + noNewlineMarker)
+		}
+	}
+}
+
+// Returns a unified diff showing the changes between the original and formatted
+// content. The diff is empty if they're the same. Line endings are kept as-is,
+// so files with windows-style line endings produce valid patches.
+func createPatch(file string, original, formatted []byte) string {
+	a := splitLines(string(original))
+	b := splitLines(string(formatted))
+	groups := groupedChanges(a, b, 3)
+	if len(groups) == 0 {
+		return ""
+	}
+
+	file = filepath.ToSlash(file)
+	from, to := quotePatchPath("a/"+file), quotePatchPath("b/"+file)
+	var patch strings.Builder
+	fmt.Fprintf(&patch, "diff --git %s %s\n--- %s\n+++ %s\n", from, to, from, to)
+	for _, group := range groups {
+		first, last := group[0], group[len(group)-1]
+		fmt.Fprintf(&patch, "@@ -%s +%s @@\n", unifiedRange(first.I1, last.I2), unifiedRange(first.J1, last.J2))
+		for _, op := range group {
+			switch op.Tag {
+			case 'e':
+				writePatchLines(&patch, ' ', a[op.I1:op.I2])
+			case 'd':
+				writePatchLines(&patch, '-', a[op.I1:op.I2])
+			case 'i':
+				writePatchLines(&patch, '+', b[op.J1:op.J2])
+			case 'r':
+				writePatchLines(&patch, '-', a[op.I1:op.I2])
+				writePatchLines(&patch, '+', b[op.J1:op.J2])
+			}
+		}
+	}
+	return patch.String()
+}
+
+type PatchHunk struct {
+	// Hunk header, such as "@@ -1,3 +1,4 @@"
+	Header string
+	// Line in the original file where the hunk starts, starting at 1
+	OldStart int
+	// Content of the affected lines before and after the change, including
+	// line endings.
+	Old, New []string
+	// The hunk as it appears in the patch, including the header
+	Text string
+}
+
+type FilePatch struct {
+	// Path relative to the root of the patch, with the "a/" or "b/" prefix
+	// removed.
+	Path  string
+	Hunks []PatchHunk
+}
+
+var hunkHeaderPatchRegex = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// Parses a path from a "---" or "+++" line, removing the leading directory
+// like `patch -p1`.
+func parsePatchPath(s string) (string, error) {
+	// timestamps are separated by a tab
+	if i := strings.IndexByte(s, '\t'); i >= 0 && !strings.HasPrefix(s, "\"") {
+		s = s[:i]
+	}
+	if strings.HasPrefix(s, "\"") {
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return "", errors.Errorf("Invalid quoted path %s", s)
+		}
+		s = unquoted
+	}
+	if s == "/dev/null" {
+		return "", errors.New("Patches that create or delete files aren't supported")
+	}
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		s = s[i+1:]
+	}
+	return s, nil
+}
+
+// Parses a unified diff containing changes to one or more files. Lines
+// outside of file headers and hunks, such as "diff --git" lines, are ignored.
+func ParsePatch(in io.Reader) ([]FilePatch, error) {
+	reader := bufio.NewReader(in)
+	var patches []FilePatch
+
+	readLine := func() (string, error) {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && len(line) > 0 {
+			err = nil
+		}
+		return line, err
+	}
+
+	for {
+		line, err := readLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			path, err := parsePatchPath(strings.TrimRight(line[4:], "\r\n"))
+			if err != nil {
+				return nil, err
+			}
+			patches = append(patches, FilePatch{Path: path})
+		case strings.HasPrefix(line, "@@ "):
+			if len(patches) == 0 {
+				return nil, errors.Errorf("Hunk without a file header: %s", strings.TrimSpace(line))
+			}
+			m := hunkHeaderPatchRegex.FindStringSubmatch(line)
+			if m == nil {
+				return nil, errors.Errorf("Invalid hunk header: %s", strings.TrimSpace(line))
+			}
+			count := func(s string) int {
+				if len(s) == 0 {
+					return 1
+				}
+				n, _ := strconv.Atoi(s)
+				return n
+			}
+			current := &patches[len(patches)-1]
+			hunk := PatchHunk{Header: strings.TrimSpace(line), Text: line}
+			hunk.OldStart, _ = strconv.Atoi(m[1])
+			oldCount, newCount := count(m[2]), count(m[4])
+			if oldCount == 0 {
+				// empty ranges refer to the line before them
+				hunk.OldStart++
+			}
+
+			// Indexes of the lines that a "no newline" marker would apply
+			// to, or -1. Context lines apply to both sides.
+			lastOld, lastNew := -1, -1
+			noNewline := func() {
+				if lastOld >= 0 {
+					hunk.Old[lastOld] = strings.TrimSuffix(hunk.Old[lastOld], "\n")
+				}
+				if lastNew >= 0 {
+					hunk.New[lastNew] = strings.TrimSuffix(hunk.New[lastNew], "\n")
+				}
+			}
+
+			for oldCount > 0 || newCount > 0 {
+				line, err := readLine()
+				if err == io.EOF {
+					return nil, errors.Errorf("Truncated hunk in %s: %s", current.Path, hunk.Header)
+				} else if err != nil {
+					return nil, err
+				}
+				hunk.Text += line
+				if line == "\n" || line == "\r\n" {
+					// some tools strip the space from empty context lines
+					line = " " + line
+				}
+
+				switch line[0] {
+				case ' ':
+					hunk.Old = append(hunk.Old, line[1:])
+					hunk.New = append(hunk.New, line[1:])
+					lastOld, lastNew = len(hunk.Old)-1, len(hunk.New)-1
+					oldCount--
+					newCount--
+				case '-':
+					hunk.Old = append(hunk.Old, line[1:])
+					lastOld, lastNew = len(hunk.Old)-1, -1
+					oldCount--
+				case '+':
+					hunk.New = append(hunk.New, line[1:])
+					lastOld, lastNew = -1, len(hunk.New)-1
+					newCount--
+				case '\\':
+					noNewline()
+				default:
+					return nil, errors.Errorf("Invalid line in hunk %s of %s: %q", hunk.Header, current.Path, line)
+				}
+			}
+
+			// a marker can also follow the last line of the hunk
+			if next, err := reader.Peek(1); err == nil && next[0] == '\\' {
+				marker, _ := readLine()
+				hunk.Text += marker
+				noNewline()
+			}
+			current.Hunks = append(current.Hunks, hunk)
+		}
+	}
+
+	return patches, nil
+}
+
+type PatchResult struct {
+	Path string
+	// Number of hunks applied
+	Applied int
+	// Headers of hunks that were skipped because the file no longer matches
+	// them.
+	Skipped []string
+	// Set if the file couldn't be patched at all.
+	Error error
+}
+
+// Returns the index where the lines of old appear in lines. The expected
+// position is checked first, then positions further and further away from it.
+// Returns -1 if there's no match at or after minPos.
+func findLines(lines, old []string, expected, minPos int) int {
+	matches := func(pos int) bool {
+		if pos < minPos || pos+len(old) > len(lines) {
+			return false
+		}
+		for i, line := range old {
+			if lines[pos+i] != line {
+				return false
+			}
+		}
+		return true
+	}
+
+	for distance := 0; distance <= len(lines); distance++ {
+		if matches(expected - distance) {
+			return expected - distance
+		}
+		if distance > 0 && matches(expected+distance) {
+			return expected + distance
+		}
+	}
+	return -1
+}
+
+// Applies a patch to a file's lines. Returns the new lines and the headers of
+// hunks that were skipped.
+func applyHunks(lines []string, hunks []PatchHunk) ([]string, []string) {
+	var skipped []string
+	// Difference between line numbers in the patch and in the patched lines,
+	// and the first line that later hunks can apply to.
+	offset, minPos := 0, 0
+	for _, hunk := range hunks {
+		expected := hunk.OldStart - 1 + offset
+		pos := findLines(lines, hunk.Old, expected, minPos)
+		if pos < 0 {
+			skipped = append(skipped, hunk.Header)
+			continue
+		}
+
+		patched := append([]string(nil), lines[:pos]...)
+		patched = append(patched, hunk.New...)
+		lines = append(patched, lines[pos+len(hunk.Old):]...)
+		offset = pos - (hunk.OldStart - 1) + len(hunk.New) - len(hunk.Old)
+		minPos = pos + len(hunk.New)
+	}
+	return lines, skipped
+}
+
+// Applies patches to the files under rootDir. Hunks that no longer match the
+// file are skipped rather than applied in the wrong place. Hunks are matched
+// exactly, but can be found at different line numbers if lines have been added
+// or removed elsewhere in the file.
+func ApplyPatch(rootDir string, patches []FilePatch) []PatchResult {
+	var results []PatchResult
+	for _, p := range patches {
+		result := PatchResult{Path: p.Path}
+		results = append(results, result)
+		r := &results[len(results)-1]
+
+		relPath := filepath.Clean(filepath.FromSlash(p.Path))
+		if filepath.IsAbs(relPath) || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			r.Error = errors.Errorf("Path is outside of the root directory: %s", p.Path)
+			continue
+		}
+		absPath := filepath.Join(rootDir, relPath)
+		content, err := ioutil.ReadFile(absPath)
+		if err != nil {
+			r.Error = err
+			continue
+		}
+
+		lines, skipped := applyHunks(splitLines(string(content)), p.Hunks)
+		r.Skipped = skipped
+		r.Applied = len(p.Hunks) - len(skipped)
+		if r.Applied > 0 {
+			r.Error = formatters.WriteFileAtomic(absPath, []byte(strings.Join(lines, "")))
+		}
+	}
+	return results
+}
@@ -0,0 +1,71 @@
+package stylize
+
+// Reporters write machine-readable summaries of formatting results for use by
+// CI systems and other tools. A reporter is attached to the end of the results
+// pipeline, similar to CollectPatch().
+
+import (
+	"io"
+	"sort"
+
+	"github.com/bradfitz/slice"
+)
+
+type Reporter interface {
+	// Writes a report covering all of the given results, which are sorted by
+	// file path.
+	WriteReport(results []FormattingResult, out io.Writer) error
+}
+
+// Constructors for all available reporters, keyed by report format name.
+var ReporterRegistry = map[string]func() Reporter{
+	"checkstyle": func() Reporter { return &CheckstyleReporter{} },
+	"junit":      func() Reporter { return &JUnitReporter{} },
+	"sarif":      func() Reporter { return &SarifReporter{} },
+}
+
+// Returns a new reporter for the given format or nil if the format is unknown.
+func LookupReporter(format string) Reporter {
+	newReporter := ReporterRegistry[format]
+	if newReporter == nil {
+		return nil
+	}
+	return newReporter()
+}
+
+// Returns the names of all available report formats.
+func ReportFormats() []string {
+	var formats []string
+	for format := range ReporterRegistry {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// Reads all incoming results and forwards them to the output channel. When all
+// results have been read, writes a report to the output writer. Any error
+// writing the report is stored in reportErr before the output channel is
+// closed.
+func CollectReport(results <-chan FormattingResult, reporter Reporter, out io.Writer, reportErr *error) <-chan FormattingResult {
+	resultsOut := make(chan FormattingResult)
+
+	go func() {
+		defer close(resultsOut)
+
+		var allResults []FormattingResult
+		for r := range results {
+			allResults = append(allResults, r)
+			resultsOut <- r
+		}
+
+		// sort to ensure reports are consistent
+		slice.Sort(allResults, func(i, j int) bool {
+			return allResults[i].FilePath < allResults[j].FilePath
+		})
+
+		*reportErr = reporter.WriteReport(allResults, out)
+	}()
+
+	return resultsOut
+}
@@ -0,0 +1,238 @@
+package stylize
+
+// Writes reports in the Static Analysis Results Interchange Format (SARIF),
+// which is used by code scanning dashboards. See
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool        sarifTool         `json:"tool"`
+	Invocations []sarifInvocation `json:"invocations"`
+	Results     []sarifResult     `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful        bool                `json:"executionSuccessful"`
+	ToolExecutionNotifications []sarifNotification `json:"toolExecutionNotifications"`
+}
+
+type sarifNotification struct {
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+	Fixes     []sarifFix      `json:"fixes,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+type sarifFix struct {
+	Description     sarifMessage          `json:"description"`
+	ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+}
+
+type sarifArtifactChange struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Replacements     []sarifReplacement    `json:"replacements"`
+}
+
+type sarifReplacement struct {
+	DeletedRegion   sarifRegion   `json:"deletedRegion"`
+	InsertedContent *sarifMessage `json:"insertedContent,omitempty"`
+}
+
+// Writes one result per file that needs formatting. Each result has a location
+// for every hunk of the diff and a fix containing the formatted text.
+// Diagnostics from the formatters are written as separate results. Errors
+// running formatters are written as tool execution notifications.
+type SarifReporter struct{}
+
+func sarifArtifact(file string) sarifArtifactLocation {
+	return sarifArtifactLocation{URI: filepath.ToSlash(file)}
+}
+
+// Returns the rule id for a chain of formatters.
+func sarifRuleID(formatters []string) string {
+	return strings.Join(formatters, "+")
+}
+
+// Returns the line and column of the start of line i of the given lines,
+// counting from 0. Regions can't refer to lines past the end of the file, so
+// the position after the last line is the end of the last line instead.
+// Columns are counted in UTF-16 code units, which is the SARIF default.
+func sarifLineStart(lines []string, i int) (int, int) {
+	if i >= len(lines) && len(lines) > 0 {
+		last := lines[len(lines)-1]
+		return len(lines), len(utf16.Encode([]rune(last))) + 1
+	}
+	return i + 1, 1
+}
+
+func sarifFileResult(r FormattingResult) sarifResult {
+	result := sarifResult{
+		RuleID:  sarifRuleID(r.Formatters),
+		Level:   "warning",
+		Message: sarifMessage{Text: fmt.Sprintf("File needs formatting with %s", strings.Join(r.Formatters, ", "))},
+	}
+
+	a := splitLines(string(r.Original))
+	b := splitLines(string(r.Formatted))
+
+	for _, group := range groupedChanges(a, b, 3) {
+		first, last := changedLineSpan(group, len(a))
+		result.Locations = append(result.Locations, sarifLocation{
+			PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifact(r.FilePath),
+				Region:           &sarifRegion{StartLine: first, EndLine: last},
+			},
+		})
+	}
+
+	// Each replacement deletes whole lines, from the start of the first
+	// replaced line to the start of the line after the last one. Pure
+	// insertions have an empty deleted region.
+	change := sarifArtifactChange{ArtifactLocation: sarifArtifact(r.FilePath)}
+	for _, op := range diffOpCodes(a, b) {
+		if op.Tag == 'e' {
+			continue
+		}
+		startLine, startColumn := sarifLineStart(a, op.I1)
+		endLine, endColumn := sarifLineStart(a, op.I2)
+		replacement := sarifReplacement{
+			DeletedRegion: sarifRegion{StartLine: startLine, StartColumn: startColumn, EndLine: endLine, EndColumn: endColumn},
+		}
+		if op.J2 > op.J1 {
+			replacement.InsertedContent = &sarifMessage{Text: strings.Join(b[op.J1:op.J2], "")}
+		}
+		change.Replacements = append(change.Replacements, replacement)
+	}
+	result.Fixes = []sarifFix{{
+		Description:     sarifMessage{Text: "Apply formatting"},
+		ArtifactChanges: []sarifArtifactChange{change},
+	}}
+
+	return result
+}
+
+// Returns a result for a diagnostic reported by a formatter.
+func sarifDiagnosticResult(file string, d Diagnostic) sarifResult {
+	location := sarifLocation{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifact(file)}}
+	if d.Line > 0 {
+		location.PhysicalLocation.Region = &sarifRegion{StartLine: d.Line}
+	}
+	return sarifResult{
+		RuleID:    d.Formatter + "/diagnostic",
+		Level:     "warning",
+		Message:   sarifMessage{Text: d.Message},
+		Locations: []sarifLocation{location},
+	}
+}
+
+func (R *SarifReporter) WriteReport(results []FormattingResult, out io.Writer) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "stylize",
+			InformationURI: "https://github.com/justbuchanan/stylize",
+			Rules:          []sarifRule{},
+		}},
+		Invocations: []sarifInvocation{{
+			ExecutionSuccessful:        true,
+			ToolExecutionNotifications: []sarifNotification{},
+		}},
+		Results: []sarifResult{},
+	}
+
+	rules := make(map[string]bool)
+	addResult := func(result sarifResult, description string) {
+		if !rules[result.RuleID] {
+			rules[result.RuleID] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+				ID:               result.RuleID,
+				ShortDescription: sarifMessage{Text: description},
+			})
+		}
+		run.Results = append(run.Results, result)
+	}
+	for _, r := range results {
+		if r.Error != nil {
+			run.Invocations[0].ExecutionSuccessful = false
+			run.Invocations[0].ToolExecutionNotifications = append(run.Invocations[0].ToolExecutionNotifications, sarifNotification{
+				Level:     "error",
+				Message:   sarifMessage{Text: r.Error.Error()},
+				Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifact(r.FilePath)}}},
+			})
+			continue
+		}
+		if r.FormatNeeded {
+			addResult(sarifFileResult(r), fmt.Sprintf("Code should be formatted with %s", strings.Join(r.Formatters, ", ")))
+		}
+		for _, d := range r.Diagnostics {
+			addResult(sarifDiagnosticResult(r.FilePath, d), fmt.Sprintf("Problems reported by %s", d.Formatter))
+		}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
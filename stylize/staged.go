@@ -0,0 +1,119 @@
+package stylize
+
+// Helpers for staged mode, which checks and formats the content of files in
+// the git index rather than in the working tree. This is intended for use in
+// pre-commit hooks, where the index content is what's about to be committed.
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/justbuchanan/stylize/formatters"
+	"github.com/pkg/errors"
+)
+
+// Returns the content of the file as it's staged in the git index.
+// @param gitRoot top-level directory of the git repo
+// @param gitPath path relative to gitRoot
+func gitIndexContent(gitRoot, gitPath string) ([]byte, error) {
+	return runGit(gitRoot, nil, "show", ":"+filepath.ToSlash(gitPath))
+}
+
+// Replaces the staged content of a file in the git index, keeping its mode.
+func gitWriteIndexContent(gitRoot, gitPath string, content []byte) error {
+	gitPath = filepath.ToSlash(gitPath)
+
+	out, err := runGit(gitRoot, nil, "ls-files", "--stage", "--", gitPath)
+	if err != nil {
+		return err
+	}
+	// output format is "<mode> <object> <stage>\t<file>"
+	fields := strings.Fields(string(out))
+	if len(fields) < 1 {
+		return errors.Errorf("File '%s' isn't in the git index", gitPath)
+	}
+	mode := fields[0]
+
+	out, err = runGit(gitRoot, bytes.NewReader(content), "hash-object", "-w", "--no-filters", "--stdin")
+	if err != nil {
+		return err
+	}
+	object := strings.TrimSpace(string(out))
+
+	_, err = runGit(gitRoot, nil, "update-index", "--cacheinfo", mode+","+object+","+gitPath)
+	return err
+}
+
+// Applies the changes between base and other onto current with a three-way
+// merge. Returns false if the changes conflict.
+func mergeChanges(current, base, other []byte) ([]byte, bool) {
+	tmp, err := ioutil.TempDir("", "stylize-merge")
+	if err != nil {
+		return nil, false
+	}
+	defer os.RemoveAll(tmp)
+
+	var paths []string
+	for i, content := range [][]byte{current, base, other} {
+		path := filepath.Join(tmp, string(rune('a'+i)))
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			return nil, false
+		}
+		paths = append(paths, path)
+	}
+
+	// git merge-file exits with a non-zero status if there are conflicts
+	args := append([]string{"merge-file", "-p", "--quiet"}, paths...)
+	merged, err := runGit(tmp, nil, args...)
+	if err != nil {
+		return nil, false
+	}
+	return merged, true
+}
+
+// Writes formatted content for a staged file to both the git index and the
+// working tree. If the file has unstaged changes, the formatting changes are
+// merged into the working tree copy, leaving the unstaged changes intact. If
+// they conflict, the working tree is left alone.
+// @param file path relative to rootDir
+// @param staged the original content in the index
+func (ctx *StylizeContext) writeStagedContent(file string, staged, formatted []byte) error {
+	absPath := filepath.Join(ctx.RootDir, file)
+	gitPath, err := filepath.Rel(ctx.gitRoot, absPath)
+	if err != nil {
+		return err
+	}
+
+	if err = gitWriteIndexContent(ctx.gitRoot, gitPath, formatted); err != nil {
+		return err
+	}
+
+	worktree, err := ioutil.ReadFile(absPath)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(worktree, staged) {
+		return formatters.WriteFileAtomic(absPath, formatted)
+	}
+
+	merged, ok := mergeChanges(worktree, staged, formatted)
+	if !ok {
+		log.Printf("Formatting changes to '%s' overlap with unstaged changes, only the index was updated", file)
+		return nil
+	}
+	return formatters.WriteFileAtomic(absPath, merged)
+}
+
+// Returns the staged content of a file.
+// @param file path relative to rootDir
+func (ctx *StylizeContext) readStagedContent(file string) ([]byte, error) {
+	gitPath, err := filepath.Rel(ctx.gitRoot, filepath.Join(ctx.RootDir, file))
+	if err != nil {
+		return nil, err
+	}
+	return gitIndexContent(ctx.gitRoot, gitPath)
+}
@@ -0,0 +1,773 @@
+// Package stylize runs code formatters over a tree of files, either checking
+// which files need formatting or reformatting them in-place. The stylize
+// command is a thin wrapper around this package.
+package stylize
+
+// This file contains the main logic of the program. In general this is setup as
+// a "pipeline" system, meaning that functions consume input channels and send
+// their results to a returned output channel.
+//
+// The first stage is a file input. We either recursively search a given source
+// directory or we use git to get a list of files that have changed since a
+// given diffbase. Each of these inputs modes corresponds to a function that
+// returns a channel of strings, on which will be sent a series of file paths to
+// format/check.
+//
+// The next stage runs a pool of formatters asynchronously on the input file
+// paths. The formatting/checking results are then forwarded to an output
+// channel.
+//
+// From there, further operations collect stats, diffs, and log actions.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bradfitz/slice"
+	"github.com/justbuchanan/stylize/formatters"
+	"github.com/pkg/errors"
+)
+
+// Returned by FormatBytes when no formatter is configured for a file.
+var ErrNoFormatter = errors.New("no formatter applies to file")
+
+type FormattingResult struct {
+	FilePath     string
+	FormatNeeded bool
+	Patch        string
+	Error        error
+	Cache        CacheStatus
+	// Names of the formatters that were run on the file, in order.
+	Formatters []string
+	// The file content before and after formatting. Only set when formatting
+	// is needed.
+	Original, Formatted []byte
+	// Time spent running the formatters on the file.
+	Duration time.Duration
+	// Problems that the formatters reported, but didn't fix.
+	Diagnostics []Diagnostic
+}
+
+// All parameters are required!
+type StylizeContext struct {
+	// The formatters to apply, keyed by file extension, file name, or glob
+	// pattern. Each file is run through its chain of formatters in order. An
+	// empty chain leaves matching files alone.
+	Formatters map[string][]Formatter
+	// If true, extension keys in Formatters match regardless of case, so
+	// ".cpp" matches "main.CPP".
+	CaseInsensitiveExtensions bool
+	// Command-line args to pass to each formatter, keyed by formatter name.
+	FormatterArgs map[string][]string
+	// Root directory to search for files under. Paths and settings are relative
+	// to it.
+	RootDir string
+	// Optional directory relative to RootDir. If given, only files under it are
+	// examined.
+	Subdir string
+	// Path of the main config file, if any. Its settings should already be
+	// applied to the rest of the context.
+	ConfigFile string
+	// File exclude patterns. These follow the same rules as gitignore files
+	// and are relative to RootDir.
+	Exclude []string
+	// Names of files to read additional exclude patterns from in each
+	// directory, such as ".stylizeignore" or ".gitignore".
+	IgnoreFiles []string
+	// Optional configs from directories under RootDir, which are merged with
+	// Formatters, FormatterArgs, and Exclude. See config_tree.go.
+	Configs *ConfigTree
+	// If provided, only looks at files that differ from the diffbase. Otherwise looks at all files.
+	GitDiffbase string
+	// If true, only changes that touch lines modified since GitDiffbase are
+	// checked/made. Requires GitDiffbase.
+	ChangedLinesOnly bool
+	// If true, only looks at files with staged changes and checks/formats
+	// their content in the git index rather than in the working tree.
+	Staged bool
+	// If given, a patch is written to the output showing changes that the formatters would make.
+	PatchOut io.Writer
+	// If given, a report of the results is written to ReportOut in the
+	// reporter's format.
+	Reporter  Reporter
+	ReportOut io.Writer
+	// If true, formats all files in-place rather than performing a compliance check.
+	InPlace bool
+	// If true, the changes that each file needs are printed along with the
+	// results.
+	ShowDiff bool
+	// If true, Run() prints a table of stats for each formatter at the end.
+	Summary bool
+	// If given in in-place mode, the reviewer is asked which changes to make
+	// to each file instead of writing all of them.
+	Reviewer *HunkReviewer
+	// How many files to format simultaneously.
+	Parallelism int
+	// Optional cache of files known to be formatted. If nil, every file is run
+	// through its formatter.
+	Cache *ResultCache
+	// If true, formatters that support it are run by long-lived worker
+	// processes. See workers.go.
+	Workers bool
+
+	// Top-level directory of the git repo containing RootDir. Only set in
+	// staged mode.
+	gitRoot string
+	// Lines modified since GitDiffbase keyed by file path relative to
+	// RootDir. Only set if ChangedLinesOnly is true.
+	changedLines map[string][]formatters.LineRange
+
+	workerMutex sync.Mutex
+	// Worker pools keyed by formatter name
+	workerPools map[string]*workerPool
+}
+
+// Walks the given directory and sends all non-excluded files to the returned channel.
+// @param rootDir absolute path to root directory
+// @return file paths relative to rootDir
+func IterateAllFiles(rootDir string, excluder *Excluder) <-chan string {
+	return iterateFilesUnder(rootDir, "", excluder)
+}
+
+// Like IterateAllFiles(), but only walks the given directory.
+// @param subdir directory relative to rootDir. Returned paths are still
+// relative to rootDir.
+func iterateFilesUnder(rootDir, subdir string, excluder *Excluder) <-chan string {
+	files := make(chan string)
+
+	go func() {
+		defer close(files)
+		filepath.Walk(filepath.Join(rootDir, subdir), func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+
+			relPath, _ := filepath.Rel(rootDir, path)
+			isExcluded := excluder.IsExcluded(relPath, fi.IsDir())
+
+			// Skip the entire directory
+			if fi.IsDir() && isExcluded {
+				return filepath.SkipDir
+			}
+
+			if !isExcluded && !fi.IsDir() {
+				files <- relPath
+			}
+
+			return nil
+		})
+	}()
+
+	return files
+}
+
+// Finds files that have been modified since the common ancestor of HEAD and
+// diffbase and sends them onto the returned channel.
+// @return file paths relative to rootDir
+func IterateGitChangedFiles(rootDir string, excluder *Excluder, diffbase string) (<-chan string, error) {
+	changedFiles, err := gitChangedFiles(rootDir, diffbase)
+	if err != nil {
+		return nil, err
+	}
+	return iterateGitFiles(rootDir, excluder, changedFiles)
+}
+
+// Finds files with changes staged in the git index and sends them onto the
+// returned channel.
+// @return file paths relative to rootDir
+func IterateGitStagedFiles(rootDir string, excluder *Excluder) (<-chan string, error) {
+	stagedFiles, err := gitStagedFiles(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	return iterateGitFiles(rootDir, excluder, stagedFiles)
+}
+
+// Sends the non-excluded files from a list given by git onto the returned
+// channel.
+// @param gitFiles file paths relative to the root of the git repo
+// @return file paths relative to rootDir
+func iterateGitFiles(rootDir string, excluder *Excluder, gitFiles []string) (<-chan string, error) {
+	// find ancestor directory of rootDir that has the .git directory
+	gitRoot, err := gitRootDir(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// get file paths relative to root directory
+	var relPaths []string
+	for _, file := range gitFiles {
+		relPath, err := filepath.Rel(rootDir, filepath.Join(gitRoot, file))
+		if err != nil {
+			return nil, err
+		}
+		relPaths = append(relPaths, relPath)
+	}
+
+	files := make(chan string)
+	go func() {
+		defer close(files)
+
+		for _, relPath := range relPaths {
+			if excluder.IsExcluded(relPath, false) {
+				continue
+			}
+
+			// git diff will show files that have been deleted - we don't want
+			// to try to format these since they don't exist anymore.
+			// TODO: use os.IsNotExist(err) instead. this doesn't work for directories, though
+			if _, err := os.Stat(filepath.Join(rootDir, relPath)); err != nil {
+				continue
+			}
+
+			files <- relPath
+		}
+	}()
+
+	return files, nil
+}
+
+func (ctx *StylizeContext) runFormatter(file string, chain []Formatter, formatterArgs map[string][]string) FormattingResult {
+	result := FormattingResult{
+		FilePath:   file,
+		Formatters: ChainNames(chain),
+	}
+
+	absPath := filepath.Join(ctx.RootDir, file)
+	var content []byte
+	if ctx.Staged {
+		content, result.Error = ctx.readStagedContent(file)
+	} else {
+		content, result.Error = ioutil.ReadFile(absPath)
+	}
+	if result.Error != nil {
+		return result
+	}
+
+	// skip files that the cache knows are already formatted
+	var cacheKey string
+	if ctx.Cache != nil {
+		if key, ok := ctx.Cache.Key(chain, formatterArgs, ctx.RootDir, file, content); ok {
+			if ctx.Cache.IsClean(key) {
+				result.Cache = CacheHit
+				return result
+			}
+			result.Cache = CacheMiss
+			cacheKey = key
+		}
+	}
+
+	// Files are formatted the same way in check and in-place modes. In-place
+	// mode then writes the result if it differs from the original. Formatters
+	// get the absolute path, since the root may not be the working directory.
+	var formatted []byte
+	start := time.Now()
+	if ctx.ChangedLinesOnly {
+		formatted, result.Diagnostics, result.Error = formatRangesWithChain(chain, formatterArgs, absPath, ctx.changedLines[file], content)
+	} else {
+		formatted, result.Diagnostics, result.Error = formatWithChain(chain, formatterArgs, absPath, content)
+	}
+	result.Duration = time.Since(start)
+	result.FormatNeeded = result.Error == nil && !bytes.Equal(content, formatted)
+
+	if result.FormatNeeded {
+		result.Original, result.Formatted = content, formatted
+		switch {
+		case !ctx.InPlace:
+			result.Patch = createPatch(file, content, formatted)
+		case ctx.Reviewer != nil:
+			// reviewChanges() writes the changes that the user accepts
+		case ctx.Staged:
+			result.Error = ctx.writeStagedContent(file, content, formatted)
+		default:
+			result.Error = replaceFileContent(absPath, content, formatted)
+		}
+	}
+
+	// When only changed lines are checked, the rest of the file may still
+	// need formatting, so it can't be marked as clean. Files with diagnostics
+	// aren't either, since they'd be lost on a cache hit.
+	if len(cacheKey) > 0 && result.Error == nil && !result.FormatNeeded && len(result.Diagnostics) == 0 && !ctx.ChangedLinesOnly {
+		if err := ctx.Cache.MarkClean(cacheKey); err != nil {
+			log.Printf("Failed to write cache entry for '%s': %v", file, err)
+		}
+	}
+
+	return result
+}
+
+// Finds the lines modified since the diffbase and stores them by path relative
+// to the root directory.
+func (ctx *StylizeContext) loadChangedLines() error {
+	gitRoot, err := gitRootDir(ctx.RootDir)
+	if err != nil {
+		return err
+	}
+	byGitPath, err := gitChangedLines(ctx.RootDir, ctx.GitDiffbase)
+	if err != nil {
+		return err
+	}
+
+	ctx.changedLines = make(map[string][]formatters.LineRange)
+	for gitPath, ranges := range byGitPath {
+		relPath, err := filepath.Rel(ctx.RootDir, filepath.Join(gitRoot, gitPath))
+		if err != nil {
+			return err
+		}
+		ctx.changedLines[relPath] = ranges
+	}
+	return nil
+}
+
+// Forwards the files that are under ctx.Subdir.
+func (ctx *StylizeContext) filterSubdir(files <-chan string) <-chan string {
+	prefix := filepath.Clean(ctx.Subdir) + string(filepath.Separator)
+	filtered := make(chan string)
+	go func() {
+		defer close(filtered)
+		for file := range files {
+			if strings.HasPrefix(file, prefix) {
+				filtered <- file
+			}
+		}
+	}()
+	return filtered
+}
+
+// Reads all incoming results and forwards them to the output channel. When all
+// results have been read, writes the patch to the output writer.
+func CollectPatch(results <-chan FormattingResult, patchOut io.Writer) <-chan FormattingResult {
+	resultsOut := make(chan FormattingResult)
+
+	go func() {
+		defer close(resultsOut)
+
+		// collect relevant results from the input channel and forward them to the output
+		var formatResults []FormattingResult
+		for r := range results {
+			if r.Error == nil && (r.FormatNeeded || len(r.Diagnostics) > 0) {
+				formatResults = append(formatResults, r)
+			}
+			resultsOut <- r
+		}
+
+		// sort to ensure patches are consistent
+		slice.Sort(formatResults, func(i, j int) bool {
+			return formatResults[i].FilePath < formatResults[j].FilePath
+		})
+
+		// write patch output. Diagnostics are written as comments before the
+		// file's changes, which tools that apply patches skip.
+		for _, r := range formatResults {
+			for _, d := range r.Diagnostics {
+				fmt.Fprintf(patchOut, "# %s\n", d.describe(r.FilePath))
+			}
+			patchOut.Write([]byte(r.Patch))
+		}
+	}()
+
+	return resultsOut
+}
+
+// Returns the chain of formatters that apply to the given file or nil if there
+// aren't any, along with the formatter args to use. Keys in ctx.Formatters can
+// be extensions, file names, or glob patterns, and the most specific match
+// wins (see match.go). Every way of selecting files goes through here, so they
+// all choose formatters the same way. Returns an error if a config that
+// applies to the file is invalid.
+func (ctx *StylizeContext) formattersForFile(file string) ([]Formatter, map[string][]string, error) {
+	settings := ctx.settingsForFile(file)
+	if settings.err != nil {
+		return nil, nil, settings.err
+	}
+	chain := lookupFormatters(settings.formatters, file, ctx.CaseInsensitiveExtensions)
+	if ctx.Workers && chain != nil {
+		chain = ctx.withWorkers(chain)
+	}
+	return chain, settings.formatterArgs, nil
+}
+
+// Formats the given content as if it were the contents of the file at path,
+// using the formatter and arguments configured in ctx. Returns ErrNoFormatter
+// if no formatter applies to the file.
+// @param path relative to ctx.RootDir, or absolute for files outside of it
+func FormatBytes(ctx *StylizeContext, path string, content []byte) ([]byte, error) {
+	chain, formatterArgs, err := ctx.formattersForFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, ErrNoFormatter
+	}
+
+	return FormatWithChain(chain, formatterArgs, ctx.absPath(path), content)
+}
+
+// Returns the absolute path of a file. Formatters are given absolute paths
+// so that they find the right settings when the root directory isn't the
+// working directory.
+// @param path relative to ctx.RootDir, or absolute
+func (ctx *StylizeContext) absPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(ctx.RootDir, path)
+}
+
+// Returns the path of a file relative to ctx.RootDir and whether it's excluded.
+// Files outside of the root directory are never excluded and keep their
+// absolute path.
+func (ctx *StylizeContext) resolvePath(absPath string) (string, bool) {
+	relPath, err := filepath.Rel(ctx.RootDir, absPath)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return absPath, false
+	}
+	return relPath, ctx.newExcluder().IsExcluded(relPath, false)
+}
+
+// Reads content from in, formats it as if it were the contents of the file at
+// path, and writes the result to out. Excluded files are copied to out
+// unchanged. Returns ErrNoFormatter if no formatter applies to the file.
+// @param path the file's path, either absolute or relative to the working
+// directory. The file doesn't need to exist.
+func FormatReader(ctx *StylizeContext, path string, in io.Reader, out io.Writer) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	relPath, excluded := ctx.resolvePath(absPath)
+	if !excluded {
+		if content, err = FormatBytes(ctx, relPath, content); err != nil {
+			return err
+		}
+	}
+
+	_, err = out.Write(content)
+	return err
+}
+
+func (ctx *StylizeContext) RunFormattersOnFiles(fileChan <-chan string) <-chan FormattingResult {
+	// use semaphore to limit how many formatting operations we run in parallel
+	semaphore := make(chan int, ctx.Parallelism)
+	var wg sync.WaitGroup
+
+	resulstOut := make(chan FormattingResult)
+	go func() {
+		// Files for formatters that support batching are collected by formatter
+		// and run in chunks once all files are known.
+		// Files are grouped by formatter and by the config directory that
+		// their args come from.
+		batches := make(map[string][]string)
+		batchChains := make(map[string][]Formatter)
+		batchArgs := make(map[string]map[string][]string)
+
+		for file := range fileChan {
+			chain, formatterArgs, err := ctx.formattersForFile(file)
+			if err != nil {
+				resulstOut <- FormattingResult{FilePath: file, Error: err}
+				continue
+			}
+			if len(chain) == 0 {
+				continue
+			}
+			if ctx.canBatch(chain) {
+				key := chain[0].Name() + "\x00" + ctx.settingsForFile(file).dir
+				batches[key] = append(batches[key], file)
+				batchChains[key] = chain
+				batchArgs[key] = formatterArgs
+				continue
+			}
+
+			wg.Add(1)
+			semaphore <- 0 // acquire
+			go func(file string, chain []Formatter, formatterArgs map[string][]string) {
+				resulstOut <- ctx.runFormatter(file, chain, formatterArgs)
+				wg.Done()
+				<-semaphore // release
+			}(file, chain, formatterArgs)
+		}
+
+		for key, files := range batches {
+			for _, chunk := range batchChunks(files, ctx.Parallelism) {
+				wg.Add(1)
+				semaphore <- 0 // acquire
+				go func(chunk []string, chain []Formatter, formatterArgs map[string][]string) {
+					for _, r := range ctx.runBatch(chunk, chain, formatterArgs) {
+						resulstOut <- r
+					}
+					wg.Done()
+					<-semaphore // release
+				}(chunk, batchChains[key], batchArgs[key])
+			}
+		}
+
+		wg.Wait()
+		close(resulstOut)
+	}()
+
+	return resulstOut
+}
+
+type RunStats struct {
+	Change, Total, Error int
+	// Number of files that were skipped or checked because of the result cache.
+	CacheHits, CacheMisses int
+	// Stats for each formatter chain, keyed by the chain's formatter names
+	// joined by " + ".
+	Formatters map[string]*FormatterStats
+}
+
+type FormatterStats struct {
+	Checked, Changed, Errored int
+	// Number of lines added and removed by formatting
+	LinesAdded, LinesRemoved int
+	// Total time spent running the formatters. Files are formatted in
+	// parallel, so this can be longer than the run itself.
+	Duration time.Duration
+}
+
+type LogOptions struct {
+	InPlace bool
+	// If true, prints the changes that each file needs.
+	ShowDiff bool
+	// If true, prints a table of the stats for each formatter at the end.
+	Summary bool
+}
+
+// Consumes the input channel, logging all actions made and collecting stats.
+// If the output is a terminal, prints files that are checked, but don't need formatting.
+func LogActionsAndCollectStats(results <-chan FormattingResult, inPlace bool) RunStats {
+	return LogActionsWithOptions(results, LogOptions{InPlace: inPlace})
+}
+
+// Like LogActionsAndCollectStats(), but with options for printing diffs and a
+// summary table. Diffs are only colorized if the output is a terminal, and not
+// if the NO_COLOR environment variable is set.
+func LogActionsWithOptions(results <-chan FormattingResult, opts LogOptions) RunStats {
+	inPlace := opts.InPlace
+	// Calculate terminal width so text can be padded appropriately for line-
+	// overwriting (done only when output is a terminal).
+	var termWidth int
+	isTerm := isTerminal(os.Stderr)
+	if isTerm {
+		termWidth = int(getTermWidth(uintptr(syscall.Stderr)))
+	} else {
+		termWidth = 0
+	}
+
+	printf := func(tmp bool, format string, a ...interface{}) {
+		fmt.Fprint(os.Stderr, padToWidth(fmt.Sprintf(format, a...), termWidth))
+		if tmp {
+			// Print a \r at the end so that the next line printed overwrites
+			// this one. Printing-in-place shows that the program is working,
+			// but doesn't fill up the screen with unnecessary info
+			fmt.Fprint(os.Stderr, "\r")
+		} else {
+			fmt.Fprint(os.Stderr, "\n")
+		}
+	}
+
+	// never write escape codes to pipes or files
+	color := isTerm && useColor(os.Stderr)
+
+	// iterate through all results, collecting basic stats and logging actions.
+	stats := RunStats{Formatters: make(map[string]*FormatterStats)}
+	for r := range results {
+		stats.Total++
+
+		chainName := strings.Join(r.Formatters, " + ")
+		fstats := stats.Formatters[chainName]
+		if fstats == nil {
+			fstats = &FormatterStats{}
+			stats.Formatters[chainName] = fstats
+		}
+		fstats.Checked++
+		fstats.Duration += r.Duration
+
+		switch r.Cache {
+		case CacheHit:
+			stats.CacheHits++
+		case CacheMiss:
+			stats.CacheMisses++
+		}
+
+		if r.Error != nil {
+			if inPlace {
+				printf(false, "Error formatting file '%s': %q", r.FilePath, r.Error)
+			} else {
+				printf(false, "Error checking file '%s': %q", r.FilePath, r.Error)
+			}
+			stats.Error++
+			fstats.Errored++
+			continue
+		}
+
+		for _, d := range r.Diagnostics {
+			printf(false, "%s", d.describe(r.FilePath))
+		}
+
+		if r.FormatNeeded {
+			stats.Change++
+
+			if inPlace {
+				printf(false, "Formatted: '%s'", r.FilePath)
+			} else {
+				printf(false, "Needs formatting: '%s'", r.FilePath)
+			}
+
+			fstats.Changed++
+			added, removed := countChangedLines(r.Original, r.Formatted)
+			fstats.LinesAdded += added
+			fstats.LinesRemoved += removed
+
+			if opts.ShowDiff {
+				patch := r.Patch
+				if len(patch) == 0 {
+					patch = createPatch(r.FilePath, r.Original, r.Formatted)
+				}
+				fmt.Fprint(os.Stderr, colorizePatch(patch, color))
+			}
+		} else if isTerm {
+			printf(true, "Checked '%s'", r.FilePath)
+		}
+	}
+
+	if inPlace {
+		printf(false, "%d / %d formatted", stats.Change, stats.Total)
+	} else {
+		printf(false, "%d / %d need formatting", stats.Change, stats.Total)
+	}
+	if stats.CacheHits+stats.CacheMisses > 0 {
+		printf(false, "Cache: %d hits, %d misses", stats.CacheHits, stats.CacheMisses)
+	}
+	if opts.Summary && len(stats.Formatters) > 0 {
+		printSummaryTable(os.Stderr, stats.Formatters)
+	}
+
+	return stats
+}
+
+// Prints a table of per-formatter stats, sorted by formatter name.
+func printSummaryTable(out io.Writer, formatterStats map[string]*FormatterStats) {
+	var names []string
+	nameWidth := len("Formatter")
+	for name := range formatterStats {
+		names = append(names, name)
+		if len(name) > nameWidth {
+			nameWidth = len(name)
+		}
+	}
+	sort.Strings(names)
+
+	// numbers are right-aligned and names are padded to be left-aligned
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "%-*s\tFiles\tChanged\tErrors\tAdded\tRemoved\tTime\t\n", nameWidth, "Formatter")
+	for _, name := range names {
+		s := formatterStats[name]
+		fmt.Fprintf(w, "%-*s\t%d\t%d\t%d\t+%d\t-%d\t%s\t\n", nameWidth, name, s.Checked, s.Changed, s.Errored, s.LinesAdded, s.LinesRemoved, s.Duration.Round(time.Millisecond))
+	}
+	w.Flush()
+}
+
+// @param gitDiffbase If provided, only looks at files that differ from the
+// diffbase. Otherwise looks at all files.
+//
+// @param formatters A map of file extension -> formatter chain
+// @return (changeCount, totalCount, errCount)
+func (ctx *StylizeContext) Run() (RunStats, error) {
+	if ctx.InPlace && ctx.PatchOut != nil {
+		return RunStats{}, errors.New("Patch output writer should only be provided in non-inplace runs")
+	}
+	if ctx.Reviewer != nil && !ctx.InPlace {
+		return RunStats{}, errors.New("Reviewing changes requires in-place mode")
+	}
+	if !filepath.IsAbs(ctx.RootDir) {
+		return RunStats{}, errors.Errorf("root directory should be an absolute path: '%s'", ctx.RootDir)
+	}
+
+	if ctx.Reporter != nil && ctx.ReportOut == nil {
+		return RunStats{}, errors.New("Report output writer should be provided along with a reporter")
+	}
+	if ctx.Staged && len(ctx.GitDiffbase) > 0 {
+		return RunStats{}, errors.New("Staged mode can't be combined with a git diffbase")
+	}
+	if ctx.ChangedLinesOnly {
+		if len(ctx.GitDiffbase) == 0 {
+			return RunStats{}, errors.New("Formatting only changed lines requires a git diffbase")
+		}
+		if err := ctx.loadChangedLines(); err != nil {
+			return RunStats{}, err
+		}
+	}
+
+	excluder := ctx.newExcluder()
+	defer ctx.CloseWorkers()
+
+	// setup file source
+	var err error
+	var fileChan <-chan string
+	if ctx.Staged {
+		log.Print("Examining files with staged changes")
+		if ctx.gitRoot, err = gitRootDir(ctx.RootDir); err != nil {
+			return RunStats{}, err
+		}
+		fileChan, err = IterateGitStagedFiles(ctx.RootDir, excluder)
+		if err != nil {
+			return RunStats{}, err
+		}
+	} else if len(ctx.GitDiffbase) > 0 {
+		log.Printf("Examining files that have changed in git since %s", ctx.GitDiffbase)
+		fileChan, err = IterateGitChangedFiles(ctx.RootDir, excluder, ctx.GitDiffbase)
+		if err != nil {
+			return RunStats{}, err
+		}
+	} else {
+		log.Print("Examining all files")
+		fileChan = iterateFilesUnder(ctx.RootDir, ctx.Subdir, excluder)
+	}
+	// lists of files from git cover the whole repo
+	if len(ctx.Subdir) > 0 {
+		fileChan = ctx.filterSubdir(fileChan)
+	}
+
+	// run formatter on all files
+	results := ctx.RunFormattersOnFiles(fileChan)
+
+	// ask which changes to write if requested
+	if ctx.Reviewer != nil {
+		results = ctx.reviewChanges(results)
+	}
+
+	// write patch to output if requested
+	if ctx.PatchOut != nil {
+		results = CollectPatch(results, ctx.PatchOut)
+	}
+
+	// write report to output if requested
+	var reportErr error
+	if ctx.Reporter != nil {
+		results = CollectReport(results, ctx.Reporter, ctx.ReportOut, &reportErr)
+	}
+
+	stats := LogActionsWithOptions(results, LogOptions{InPlace: ctx.InPlace, ShowDiff: ctx.ShowDiff, Summary: ctx.Summary})
+	if reportErr != nil {
+		return stats, errors.Wrap(reportErr, "Failed to write report")
+	}
+	return stats, nil
+}
@@ -0,0 +1,2127 @@
+package stylize
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/justbuchanan/stylize/formatters"
+	"github.com/justbuchanan/stylize/plugin"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+var (
+	generateGoldens = flag.Bool("generate_goldens", false, "Generate golden files")
+	PARALLELISM     = 5
+)
+
+func TestMain(m *testing.M) {
+	// The test binary doubles as a fake plugin for TestPlugins
+	if os.Getenv("STYLIZE_FAKE_PLUGIN") == "1" {
+		if err := plugin.Serve(&fakePlugin{}, os.Stdin, os.Stdout); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	flag.Parse()
+	os.Exit(m.Run())
+}
+
+// TODO: delete this and use ctx.Run() directly
+func runStylize(t *testing.T, formatters map[string][]Formatter, formatterArgs map[string][]string, rootDir string, exclude []string, gitDiffbase string, patchOut io.Writer, inPlace bool, parallelism int) RunStats {
+	ctx := StylizeContext{
+		Formatters:    formatters,
+		FormatterArgs: formatterArgs,
+		RootDir:       rootDir,
+		Exclude:       exclude,
+		GitDiffbase:   gitDiffbase,
+		PatchOut:      patchOut,
+		InPlace:       inPlace,
+		Parallelism:   parallelism,
+	}
+	stats, err := ctx.Run()
+	tCheckErr(t, err)
+	return stats
+}
+
+func expectMatch(t *testing.T, match bool, pattern, file string) {
+	m := NewExcluder("", strings.Split(pattern, ","), nil).IsExcluded(file, false)
+	if m != match {
+		t.Logf("'%s' '%s'", pattern, file)
+		if match {
+			t.Error("Expected match, but didn't get one")
+		} else {
+			t.Error("It matched, but wasnt supposed to")
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	expectMatch(t, true, "exclude/", "exclude/file.cpp")
+	expectMatch(t, true, "exclude", "exclude/file.cpp")
+	expectMatch(t, false, "exclud", "exclude/file.cpp")
+	expectMatch(t, false, "bbb", "bbb.cpp")
+	expectMatch(t, true, "*", "bad.cpp")
+	expectMatch(t, true, "*", "files/bad.cpp")
+	expectMatch(t, true, "/files", "files/bad.cpp")
+	expectMatch(t, false, "/files", "sub/files/bad.cpp")
+	expectMatch(t, true, "files", "sub/files/bad.cpp")
+	expectMatch(t, true, "sub/*.cpp", "sub/bad.cpp")
+	expectMatch(t, false, "sub/*.cpp", "sub/dir/bad.cpp")
+	expectMatch(t, true, "sub/**/*.cpp", "sub/dir/bad.cpp")
+	expectMatch(t, true, "sub/**/*.cpp", "sub/bad.cpp")
+	expectMatch(t, true, "**/dir/*.cpp", "a/b/dir/bad.cpp")
+	expectMatch(t, true, "sub/**", "sub/dir/bad.cpp")
+	expectMatch(t, true, "*.cpp", "a/b/bad.cpp")
+	expectMatch(t, false, "bad.cpp/", "bad.cpp")
+	expectMatch(t, true, "bad/", "bad/file.cpp")
+	expectMatch(t, false, "*.cpp,!good.cpp", "good.cpp")
+	expectMatch(t, true, "*.cpp,!good.cpp", "bad.cpp")
+	// files can't be re-included if their parent directory is excluded
+	expectMatch(t, true, "dir,!dir/good.cpp", "dir/good.cpp")
+	expectMatch(t, false, "# comment", "# comment")
+	expectMatch(t, true, "\\#file", "#file")
+}
+
+func TestStylizeIgnoreFiles(t *testing.T) {
+	tmp := mktmp(t)
+	writeFile := func(file, content string) {
+		p := path.Join(tmp, file)
+		tCheckErr(t, os.MkdirAll(path.Dir(p), 0755))
+		tCheckErr(t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+	writeFile(".stylizeignore", "*.gen.go\n/build/\n")
+	writeFile(".gitignore", "out/\n")
+	writeFile("a.go", "")
+	writeFile("a.gen.go", "")
+	writeFile("build/b.go", "")
+	writeFile("sub/.stylizeignore", "*.go\n!keep.go\n")
+	writeFile("sub/build/c.py", "")
+	writeFile("sub/d.go", "")
+	writeFile("sub/keep.go", "")
+	writeFile("out/e.go", "")
+
+	var files []string
+	for f := range IterateAllFiles(tmp, NewExcluder(tmp, nil, []string{StylizeIgnoreFile})) {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	expected := ".gitignore,.stylizeignore,a.go,out/e.go,sub/.stylizeignore,sub/build/c.py,sub/keep.go"
+	if strings.Join(files, ",") != expected {
+		t.Fatalf("Unexpected files: %v", files)
+	}
+
+	// .gitignore files are only used if requested
+	excluder := NewExcluder(tmp, nil, []string{StylizeIgnoreFile, ".gitignore"})
+	if !excluder.IsExcluded("out/e.go", false) {
+		t.Fatal("out/e.go should be excluded by .gitignore")
+	}
+	if !excluder.IsExcluded("sub/d.go", false) || excluder.IsExcluded("sub/keep.go", false) {
+		t.Fatal("Patterns from sub/.stylizeignore weren't applied")
+	}
+
+	os.RemoveAll(tmp)
+}
+
+func TestCreatePatch(t *testing.T) {
+	goldenFile := "testdata/patch.golden"
+
+	var patchOut io.Writer
+
+	// only used when not generating goldens
+	var patchBuffer bytes.Buffer
+
+	if *generateGoldens {
+		patchFile, err := os.Create(goldenFile)
+		tCheckErr(t, err)
+		patchOut = patchFile
+		defer patchFile.Close()
+		t.Logf("Writing golden file to %s", goldenFile)
+	} else {
+		patchOut = &patchBuffer
+	}
+
+	absDirPath, _ := filepath.Abs("testdata")
+	runStylize(t, LoadDefaultFormatters(), nil, absDirPath, []string{"exclude"}, "", patchOut, false, PARALLELISM)
+
+	if !*generateGoldens {
+		assertGoldenMatch(t, goldenFile, patchBuffer.String())
+	}
+
+	// applying the patch should format everything
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+	dir := copyTestData(t, tmp)
+	patchPath, _ := filepath.Abs(goldenFile)
+	runCmd(t, dir, "git", "apply", patchPath)
+	if !isDirectoryFormatted(t, dir, []string{"exclude"}) {
+		t.Fatal("Directory not formatted after applying patch")
+	}
+}
+
+// Checks that patches for tricky content can be applied by git and patch.
+func TestPatchEdgeCases(t *testing.T) {
+	tests := []struct {
+		name                string
+		original, formatted string
+	}{
+		{"no_newline", "a\nb\nc", "a\nB\nc"},
+		{"add_newline", "a\nb", "a\nb\n"},
+		{"remove_newline", "a\nb\n", "a\nb"},
+		{"crlf", "a\r\nb\r\nc\r\n", "a\r\nB\r\nc\r\n"},
+		{"crlf_no_newline", "a\r\nb", "A\r\nb"},
+		{"to_crlf", "a\nb\n", "a\r\nb\r\n"},
+		{"empty_lines", "\n\n\nx\n\n\n", "\nx\n"},
+	}
+
+	for _, tool := range [][]string{{"git", "apply"}, {"patch", "-p1", "-s", "-i"}} {
+		if _, err := exec.LookPath(tool[0]); err != nil {
+			t.Logf("Skipping %s, it's not installed", tool[0])
+			continue
+		}
+		for _, test := range tests {
+			tmp := mktmp(t)
+			file := filepath.Join(tmp, test.name)
+			tCheckErr(t, ioutil.WriteFile(file, []byte(test.original), 0644))
+			patchFile := filepath.Join(tmp, "patch.txt")
+			patch := createPatch(test.name, []byte(test.original), []byte(test.formatted))
+			tCheckErr(t, ioutil.WriteFile(patchFile, []byte(patch), 0644))
+
+			runCmd(t, tmp, tool[0], append(tool[1:], patchFile)...)
+			content, err := ioutil.ReadFile(file)
+			tCheckErr(t, err)
+			if string(content) != test.formatted {
+				t.Errorf("%s: %s produced %q, expected %q. Patch:\n%s", test.name, tool[0], content, test.formatted, patch)
+			}
+
+			// stylize should be able to apply it too
+			patches, err := ParsePatch(strings.NewReader(patch))
+			tCheckErr(t, err)
+			tCheckErr(t, ioutil.WriteFile(file, []byte(test.original), 0644))
+			results := ApplyPatch(tmp, patches)
+			content, _ = ioutil.ReadFile(file)
+			if len(results) != 1 || results[0].Error != nil || len(results[0].Skipped) > 0 || string(content) != test.formatted {
+				t.Errorf("%s: ApplyPatch() produced %q, expected %q. Results: %+v", test.name, content, test.formatted, results)
+			}
+			os.RemoveAll(tmp)
+		}
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+
+	var original, formatted []string
+	for i := 1; i <= 20; i++ {
+		original = append(original, fmt.Sprintf("line %d", i))
+		formatted = append(formatted, fmt.Sprintf("line %d", i))
+	}
+	formatted[1] = "LINE 2"
+	formatted[17] = "LINE 18"
+	patch := createPatch("a.txt", []byte(strings.Join(original, "\n")+"\n"), []byte(strings.Join(formatted, "\n")+"\n"))
+	patches, err := ParsePatch(strings.NewReader(patch))
+	tCheckErr(t, err)
+	if len(patches) != 1 || len(patches[0].Hunks) != 2 {
+		t.Fatalf("Expected one file with two hunks, got %+v", patches)
+	}
+
+	// The file has changed since the patch was made: a line was added at the
+	// top, which shifts the second hunk, and line 2 was edited, so the first
+	// hunk no longer applies.
+	current := append([]string{"new line"}, original...)
+	current[2] = "edited line 2"
+	tCheckErr(t, ioutil.WriteFile(filepath.Join(tmp, "a.txt"), []byte(strings.Join(current, "\n")+"\n"), 0644))
+
+	results := ApplyPatch(tmp, patches)
+	if len(results) != 1 || results[0].Error != nil {
+		t.Fatalf("Unexpected results: %+v", results)
+	}
+	if results[0].Applied != 1 || len(results[0].Skipped) != 1 || results[0].Skipped[0] != patches[0].Hunks[0].Header {
+		t.Fatalf("Expected the first hunk to be skipped, got %+v", results[0])
+	}
+
+	expected := append([]string(nil), current...)
+	expected[18] = "LINE 18"
+	content, err := ioutil.ReadFile(filepath.Join(tmp, "a.txt"))
+	tCheckErr(t, err)
+	if string(content) != strings.Join(expected, "\n")+"\n" {
+		t.Fatalf("Unexpected content after patching:\n%s", content)
+	}
+
+	// paths outside of the root are refused
+	results = ApplyPatch(tmp, []FilePatch{{Path: "../a.txt", Hunks: patches[0].Hunks}})
+	if len(results) != 1 || results[0].Error == nil {
+		t.Fatal("Expected an error for a path outside of the root")
+	}
+}
+
+func isDirectoryFormatted(t *testing.T, dir string, exclude []string) bool {
+	stats := runStylize(t, LoadDefaultFormatters(), nil, dir, exclude, "", nil, false, PARALLELISM)
+	return stats.Change == 0 && stats.Error == 0
+}
+
+func TestInPlace(t *testing.T) {
+	tmp := mktmp(t)
+	dir := copyTestData(t, tmp)
+
+	exclude := []string{"exclude"}
+	t.Log("exclude: " + strings.Join(exclude, ","))
+
+	// run in-place formatting
+	stats := runStylize(t, LoadDefaultFormatters(), nil, dir, exclude, "", nil, true, PARALLELISM)
+	if stats.Error > 0 {
+		t.Fatal("Formatting failed")
+	}
+
+	if !isDirectoryFormatted(t, dir, exclude) {
+		t.Fatal("Second run of formatter showed a diff. Everything should have been fixed in-place the first time.")
+	}
+
+	// TODO: test exclude not modified
+
+	os.RemoveAll(tmp)
+}
+
+func TestInPlaceWithConfig(t *testing.T) {
+	tmp := mktmp(t)
+	dir := copyTestData(t, tmp)
+
+	cfgPath := path.Join(dir, ".stylize.yml")
+	err := ioutil.WriteFile(cfgPath, []byte("---\nformatters:\n  .py: yapf\nexclude:\n  - exclude"), 0644)
+	tCheckErr(t, err)
+	t.Logf("Wrote config file: %s", cfgPath)
+
+	cfg, err := LoadConfig(cfgPath)
+	tCheckErr(t, err)
+	t.Log("Read config file")
+	t.Log("exclude: " + strings.Join(cfg.ExcludePatterns, ","))
+
+	formatters, err := LoadFormattersFromMapping(cfg.FormattersByExt)
+	tCheckErr(t, err)
+
+	// run in-place formatting
+	stats := runStylize(t, formatters, nil, dir, cfg.ExcludePatterns, "", nil, true, PARALLELISM)
+	t.Logf("Stylize results: %d, %d, %d", stats.Change, stats.Total, stats.Error)
+
+	if stats.Change != 1 {
+		t.Fatal("One file should have changed")
+	}
+
+	stats = runStylize(t, formatters, nil, dir, cfg.ExcludePatterns, "", nil, true, PARALLELISM)
+	t.Logf("Stylize results: %d, %d, %d", stats.Change, stats.Total, stats.Error)
+
+	if stats.Change != 0 {
+		t.Fatal("No files should have changed")
+	}
+
+	os.RemoveAll(tmp)
+}
+
+func TestGitDiffbase(t *testing.T) {
+	tmp := mktmp(t)
+	dir := copyTestData(t, tmp)
+
+	// initial commit
+	runCmd(t, dir, "git", "init", "--initial-branch=main")
+	runCmd(t, dir, "git", "add", ".")
+	runCmd(t, dir, "git", "commit", "-m", "first commit")
+
+	// new branch
+	runCmd(t, dir, "git", "checkout", "-b", "other")
+
+	// add new files, delete a couple
+	runCmd(t, dir, "cp", "bad.cpp", "exclude/bad2.cpp")
+	runCmd(t, dir, "cp", "bad.cpp", "bad2.cpp")
+	runCmd(t, dir, "rm", "bad.py", "bad.cpp")
+	runCmd(t, dir, "git", "add", ".")
+	runCmd(t, dir, "git", "commit", "-m", "added files")
+
+	exclude := []string{"exclude"}
+	t.Log("exclude: " + strings.Join(exclude, ","))
+
+	// run stylize with diffbase provided
+	stats := runStylize(t, LoadDefaultFormatters(), nil, dir, exclude, "main", nil, true, PARALLELISM)
+	if stats.Change != 1 {
+		t.Fatalf("Stylize should have formatted one and only one file. Instead it was %d", stats.Change)
+	}
+	if stats.Error > 0 {
+		t.Fatalf("Error formatting files")
+	}
+
+	// git diff
+	files, err := gitChangedFiles(dir, "HEAD")
+	tCheckErr(t, err)
+
+	t.Logf("Files changed by stylize: %s", strings.Join(files, ", "))
+
+	if len(files) != 1 || files[0] != "bad2.cpp" {
+		t.Fatal("Stylize should only have formatted bad2.cpp.")
+	}
+
+	// Remove directory if test passed
+	os.RemoveAll(tmp)
+}
+
+func TestLoadFormattersFromMappingUnknown(t *testing.T) {
+	_, err := LoadFormattersFromMapping(map[string]FormatterList{".py": {"not-a-formatter"}})
+	if err == nil {
+		t.Fatal("Expected an error for an unknown formatter")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	ctx := StylizeContext{
+		Formatters: map[string][]Formatter{".go": {LookupFormatter("gofmt")}},
+	}
+
+	formatted, err := FormatBytes(&ctx, "main.go", []byte("package main\nfunc main()"))
+	tCheckErr(t, err)
+	if string(formatted) != "package main\n\nfunc main()\n" {
+		t.Fatalf("Unexpected output: %q", formatted)
+	}
+
+	if _, err = FormatBytes(&ctx, "main.py", []byte("")); err != ErrNoFormatter {
+		t.Fatalf("Expected ErrNoFormatter, got %v", err)
+	}
+}
+
+func TestFormatReader(t *testing.T) {
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+	ctx := StylizeContext{
+		Formatters: map[string][]Formatter{".go": {LookupFormatter("gofmt")}},
+		RootDir:    tmp,
+		Exclude:    []string{"vendor"},
+	}
+	unformatted := "package main\nfunc main()"
+
+	var out bytes.Buffer
+	tCheckErr(t, FormatReader(&ctx, path.Join(tmp, "main.go"), strings.NewReader(unformatted), &out))
+	if out.String() != "package main\n\nfunc main()\n" {
+		t.Fatalf("Unexpected output: %q", out.String())
+	}
+
+	// excluded files are passed through unchanged
+	out.Reset()
+	tCheckErr(t, FormatReader(&ctx, path.Join(tmp, "vendor", "main.go"), strings.NewReader(unformatted), &out))
+	if out.String() != unformatted {
+		t.Fatalf("Excluded file was formatted: %q", out.String())
+	}
+
+	if err := FormatReader(&ctx, path.Join(tmp, "main.py"), strings.NewReader(""), &out); err != ErrNoFormatter {
+		t.Fatalf("Expected ErrNoFormatter, got %v", err)
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	tmp := mktmp(t)
+	dir := copyTestData(t, tmp)
+
+	cache, err := NewResultCache(path.Join(tmp, "cache"))
+	tCheckErr(t, err)
+
+	ctx := StylizeContext{
+		Formatters:  map[string][]Formatter{".go": {LookupFormatter("gofmt")}},
+		RootDir:     dir,
+		Parallelism: PARALLELISM,
+		Cache:       cache,
+	}
+
+	// Nothing is cached on the first run. Only good.go is recorded as clean.
+	stats, err := ctx.Run()
+	tCheckErr(t, err)
+	if stats.CacheHits != 0 || stats.CacheMisses != 2 {
+		t.Fatalf("Expected 0 hits and 2 misses, got %d and %d", stats.CacheHits, stats.CacheMisses)
+	}
+
+	stats, err = ctx.Run()
+	tCheckErr(t, err)
+	if stats.CacheHits != 1 || stats.CacheMisses != 1 || stats.Change != 1 {
+		t.Fatalf("Expected 1 hit, 1 miss, and 1 change, got %d, %d, and %d", stats.CacheHits, stats.CacheMisses, stats.Change)
+	}
+
+	// Changing a file's content invalidates its entry
+	err = ioutil.WriteFile(path.Join(dir, "good.go"), []byte("package main\nfunc main()\n"), 0644)
+	tCheckErr(t, err)
+	stats, err = ctx.Run()
+	tCheckErr(t, err)
+	if stats.CacheHits != 0 || stats.Change != 2 {
+		t.Fatalf("Expected 0 hits and 2 changes, got %d and %d", stats.CacheHits, stats.Change)
+	}
+
+	// formatters that run as workers get separate entries
+	gofmt := LookupFormatter("gofmt")
+	content := []byte("package main\n")
+	key, ok := cache.Key([]Formatter{gofmt}, nil, dir, "a.go", content)
+	workerKey, workerOk := cache.Key([]Formatter{&pooledFormatter{Formatter: gofmt}}, nil, dir, "a.go", content)
+	if !ok || !workerOk || key == workerKey {
+		t.Error("Expected different cache keys with and without workers")
+	}
+
+	tCheckErr(t, cache.Clean())
+	os.RemoveAll(tmp)
+}
+
+// Test formatter that appends a line to its input.
+type appendFormatter struct {
+	name, line string
+}
+
+func (F *appendFormatter) Name() string             { return F.name }
+func (F *appendFormatter) IsInstalled() bool        { return true }
+func (F *appendFormatter) FileExtensions() []string { return []string{".txt"} }
+
+func (F *appendFormatter) FormatToBuffer(args []string, file string, in io.Reader, out io.Writer) error {
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	_, err := io.WriteString(out, F.line+strings.Join(args, " ")+"\n")
+	return err
+}
+
+func (F *appendFormatter) FormatInPlace(args []string, file string) error {
+	return errors.New("not implemented")
+}
+
+func TestFormatterChain(t *testing.T) {
+	chain := []Formatter{&appendFormatter{"first", "1"}, &appendFormatter{"second", "2"}}
+	formatterArgs := map[string][]string{"second": {"arg"}}
+
+	formatted, err := FormatWithChain(chain, formatterArgs, "file.txt", []byte("0\n"))
+	tCheckErr(t, err)
+	if string(formatted) != "0\n1\n2arg\n" {
+		t.Fatalf("Unexpected output: %q", formatted)
+	}
+
+	// in-place formatting writes the combined result once
+	tmp := mktmp(t)
+	file := path.Join(tmp, "file.txt")
+	tCheckErr(t, ioutil.WriteFile(file, []byte("0\n"), 0644))
+
+	ctx := StylizeContext{
+		Formatters:    map[string][]Formatter{".txt": chain},
+		FormatterArgs: formatterArgs,
+		RootDir:       tmp,
+		InPlace:       true,
+		Parallelism:   PARALLELISM,
+	}
+	stats, err := ctx.Run()
+	tCheckErr(t, err)
+	if stats.Change != 1 {
+		t.Fatalf("Expected one change, got %d", stats.Change)
+	}
+	content, err := ioutil.ReadFile(file)
+	tCheckErr(t, err)
+	if string(content) != "0\n1\n2arg\n" {
+		t.Fatalf("Unexpected file content: %q", content)
+	}
+
+	os.RemoveAll(tmp)
+}
+
+func TestLoadConfigFormatterList(t *testing.T) {
+	tmp := mktmp(t)
+	cfgPath := path.Join(tmp, ".stylize.yml")
+	err := ioutil.WriteFile(cfgPath, []byte("---\nformatters:\n  .py: [yapf, black]\n  .go: gofmt\n"), 0644)
+	tCheckErr(t, err)
+
+	cfg, err := LoadConfig(cfgPath)
+	tCheckErr(t, err)
+	if strings.Join(cfg.FormattersByExt[".py"], ",") != "yapf,black" {
+		t.Fatalf("Unexpected .py formatters: %v", cfg.FormattersByExt[".py"])
+	}
+	if strings.Join(cfg.FormattersByExt[".go"], ",") != "gofmt" {
+		t.Fatalf("Unexpected .go formatters: %v", cfg.FormattersByExt[".go"])
+	}
+
+	os.RemoveAll(tmp)
+}
+
+func TestConfigExtends(t *testing.T) {
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+	files := map[string]string{
+		"org/base.yml": `
+formatters:
+  .go: gofmt
+  .py: [yapf, black]
+exclude: [vendor]
+formatter_args:
+  clang: [--style=google]
+  yapf: [--style=pep8]
+plugin_dir: plugins
+`,
+		"org/python.yml": `
+extends: [base.yml]
+formatters:
+  .py: black
+`,
+		"repo/.stylize.yml": `
+extends: [../org/python.yml]
+exclude: [build]
+formatter_args:
+  yapf: [--style=google]
+`,
+	}
+	for file, content := range files {
+		tCheckErr(t, os.MkdirAll(filepath.Dir(filepath.Join(tmp, file)), 0755))
+		tCheckErr(t, ioutil.WriteFile(filepath.Join(tmp, file), []byte(content), 0644))
+	}
+
+	cfg, err := LoadConfig(filepath.Join(tmp, "repo", ConfigFileName))
+	tCheckErr(t, err)
+	if strings.Join(cfg.FormattersByExt[".py"], ",") != "black" || strings.Join(cfg.FormattersByExt[".go"], ",") != "gofmt" {
+		t.Errorf("Unexpected formatters: %v", cfg.FormattersByExt)
+	}
+	if strings.Join(cfg.ExcludePatterns, ",") != "vendor,build" {
+		t.Errorf("Unexpected exclude patterns: %v", cfg.ExcludePatterns)
+	}
+	if strings.Join(cfg.FormatterArgs["yapf"], ",") != "--style=google" || strings.Join(cfg.FormatterArgs["clang"], ",") != "--style=google" {
+		t.Errorf("Unexpected formatter args: %v", cfg.FormatterArgs)
+	}
+	if cfg.PluginDir != filepath.Join(tmp, "org/plugins") {
+		t.Errorf("plugin_dir should be relative to the file declaring it, got %s", cfg.PluginDir)
+	}
+
+	var out bytes.Buffer
+	tCheckErr(t, cfg.WriteAnnotated(&out, filepath.Join(tmp, "repo")))
+	sources := make(map[string]string)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if parts := strings.SplitN(line, "  # ", 2); len(parts) == 2 {
+			sources[strings.TrimSpace(parts[0])] = parts[1]
+		}
+	}
+	expectedSources := map[string]string{
+		".py: black": filepath.Join(tmp, "org/python.yml"),
+		"- vendor":   filepath.Join(tmp, "org/base.yml"),
+		"- build":    ConfigFileName,
+	}
+	for text, source := range expectedSources {
+		if sources[text] != source {
+			t.Errorf("Expected '%s' to come from %s in annotated config:\n%s", text, source, out.String())
+		}
+	}
+
+	// a base extended through two paths is only merged in once
+	tCheckErr(t, ioutil.WriteFile(filepath.Join(tmp, "org/go.yml"), []byte("extends: [base.yml]\n"), 0644))
+	tCheckErr(t, ioutil.WriteFile(filepath.Join(tmp, "repo", ConfigFileName), []byte("extends: [../org/python.yml, ../org/go.yml]\nexclude: [build]\n"), 0644))
+	cfg, err = LoadConfig(filepath.Join(tmp, "repo", ConfigFileName))
+	tCheckErr(t, err)
+	if strings.Join(cfg.ExcludePatterns, ",") != "vendor,build" {
+		t.Errorf("Unexpected exclude patterns with a shared base: %v", cfg.ExcludePatterns)
+	}
+	if len(cfg.Files()) != 4 {
+		t.Errorf("Unexpected config files: %v", cfg.Files())
+	}
+
+	// the same pattern from different files is kept, so a local pattern can
+	// exclude a file again after a base negates it, and local settings
+	// override the bases even when they're false
+	tCheckErr(t, ioutil.WriteFile(filepath.Join(tmp, "org/base.yml"), []byte("exclude: [\"*.gen.go\"]\nrespect_gitignore: true\ncase_insensitive_extensions: true\n"), 0644))
+	tCheckErr(t, ioutil.WriteFile(filepath.Join(tmp, "org/go.yml"), []byte("extends: [base.yml]\nexclude: [\"!special.gen.go\"]\n"), 0644))
+	tCheckErr(t, ioutil.WriteFile(filepath.Join(tmp, "repo", ConfigFileName), []byte("extends: [../org/go.yml]\nexclude: [\"*.gen.go\"]\nrespect_gitignore: false\n"), 0644))
+	cfg, err = LoadConfig(filepath.Join(tmp, "repo", ConfigFileName))
+	tCheckErr(t, err)
+	if strings.Join(cfg.ExcludePatterns, ",") != "*.gen.go,!special.gen.go,*.gen.go" {
+		t.Errorf("Unexpected exclude patterns after a negation: %v", cfg.ExcludePatterns)
+	}
+	if cfg.RespectGitignore || !cfg.CaseInsensitiveExtensions {
+		t.Errorf("Local settings should override the base, got respect_gitignore: %v, case_insensitive_extensions: %v", cfg.RespectGitignore, cfg.CaseInsensitiveExtensions)
+	}
+
+	// cycles and missing files are errors
+	tCheckErr(t, ioutil.WriteFile(filepath.Join(tmp, "org/base.yml"), []byte("extends: [python.yml]\n"), 0644))
+	if _, err = LoadConfig(filepath.Join(tmp, "repo", ConfigFileName)); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("Expected a cycle error, got %v", err)
+	}
+	tCheckErr(t, os.Remove(filepath.Join(tmp, "org/python.yml")))
+	if _, err = LoadConfig(filepath.Join(tmp, "repo", ConfigFileName)); err == nil || os.IsNotExist(err) {
+		t.Errorf("Expected an error for a missing base config, got %v", err)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	registry := FormatterRegistry
+	defer func() { FormatterRegistry = registry }()
+	RegisterFormatter(&appendFormatter{"first", "1"})
+	RegisterFormatter(&appendFormatter{"second", "2"})
+
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+	cfgPath := filepath.Join(tmp, ConfigFileName)
+	load := func(content string) (*Config, error) {
+		tCheckErr(t, ioutil.WriteFile(cfgPath, []byte(content), 0644))
+		return LoadConfig(cfgPath)
+	}
+
+	// unknown keys are rejected with their position, including in nested
+	// settings
+	_, err := load("formatters:\n  .txt: first\nexlude: [build]\n")
+	if err == nil || !strings.Contains(err.Error(), cfgPath+":3:1: unknown key 'exlude' (did you mean 'exclude'?)") {
+		t.Errorf("Expected an unknown key error, got %v", err)
+	}
+	_, err = load("custom_formatters:\n  - name: x\n    comand: cat\n")
+	if err == nil || !strings.Contains(err.Error(), ":3:5: unknown key 'comand'") {
+		t.Errorf("Expected an unknown key error, got %v", err)
+	}
+
+	cfg, err := load(`formatters:
+  .txt: first
+  txt: first
+  ts2: first
+  BUILD: first
+  "*.pb.txt": [first, frist]
+exclude:
+  - /build/out
+  - /usr/lib/x
+  - ` + filepath.Join(tmp, "gen") + `
+formatter_args:
+  second: [-v]
+  first: [-v]
+`)
+	tCheckErr(t, err)
+	var problems []string
+	for _, p := range cfg.Validate(false) {
+		problems = append(problems, fmt.Sprintf("%d:%d %v %s", p.Line, p.Column, p.Warning, p.Message))
+	}
+	expected := []string{
+		"3:3 false 'txt' only matches files named txt; extensions start with '.' (did you mean '.txt'?)",
+		"4:3 false 'ts2' only matches files named ts2; extensions start with '.'",
+		"6:3 false unknown formatter 'frist' (did you mean 'first'?)",
+		"10:5 false exclude pattern '" + filepath.Join(tmp, "gen") + "' is an absolute path; patterns are relative to the config's directory",
+		"12:3 true formatter_args given for second, which isn't enabled for any files",
+	}
+	if strings.Join(problems, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected problems:\n%s\nExpected:\n%s", strings.Join(problems, "\n"), strings.Join(expected, "\n"))
+	}
+
+	// nested configs warn about settings that only the main config can use
+	cfg, err = load("formatters:\n  .txt: first\nrespect_gitignore: true\n")
+	tCheckErr(t, err)
+	if problems := cfg.Validate(true); len(problems) != 1 || !problems[0].Warning || problems[0].Line != 3 {
+		t.Errorf("Expected a warning about respect_gitignore, got %v", problems)
+	}
+}
+
+func TestLookupFormatters(t *testing.T) {
+	registry := FormatterRegistry
+	defer func() { FormatterRegistry = registry }()
+	keys := []string{".go", "*.pb.go", "gen/*.go", ".ts", ".d.ts", "Dockerfile", "Dockerfile.*", "BUILD.bazel", ".bazel", ".cpp"}
+	mapping := map[string]FormatterList{"*_pb2.py": {NoFormatter}}
+	for _, key := range keys {
+		RegisterFormatter(&appendFormatter{key, key})
+		mapping[key] = FormatterList{key}
+	}
+	byExt, err := LoadFormattersFromMapping(mapping)
+	tCheckErr(t, err)
+
+	expected := map[string]string{
+		"main.go":          ".go",
+		"api/foo.pb.go":    "*.pb.go",
+		"gen/foo.go":       "gen/*.go",
+		"gen/foo.pb.go":    "gen/*.go",
+		"lib/index.d.ts":   ".d.ts",
+		"lib/index.ts":     ".ts",
+		"Dockerfile":       "Dockerfile",
+		"Dockerfile.prod":  "Dockerfile.*",
+		"BUILD.bazel":      "BUILD.bazel",
+		"MODULE.bazel":     ".bazel",
+		"main.CPP":         "",
+		"foo_pb2.py":       "",
+		"README":           "",
+		"src/Dockerfile.x": "Dockerfile.*",
+	}
+	for file, key := range expected {
+		if names := strings.Join(ChainNames(lookupFormatters(byExt, file, false)), ","); names != key {
+			t.Errorf("Expected %s to use '%s', got '%s'", file, key, names)
+		}
+	}
+
+	if chain := lookupFormatters(byExt, "main.CPP", true); len(chain) != 1 || chain[0].Name() != ".cpp" {
+		t.Errorf("Expected .cpp to match main.CPP when ignoring case, got %v", ChainNames(chain))
+	}
+	if chain := lookupFormatters(byExt, "foo_pb2.py", false); chain == nil || len(chain) != 0 {
+		t.Errorf("Expected an empty chain for a file mapped to %s, got %v", NoFormatter, chain)
+	}
+	if _, err := LoadFormattersFromMapping(map[string]FormatterList{".go": {NoFormatter, ".go"}}); err == nil {
+		t.Errorf("Expected an error for combining %s with other formatters", NoFormatter)
+	}
+}
+
+// Test formatter with style files
+type styledFormatter struct {
+	*appendFormatter
+	extensions, styleFiles []string
+	installed              bool
+}
+
+func (F *styledFormatter) IsInstalled() bool          { return F.installed }
+func (F *styledFormatter) FileExtensions() []string   { return F.extensions }
+func (F *styledFormatter) StyleConfigFiles() []string { return F.styleFiles }
+
+func TestSurveyRepo(t *testing.T) {
+	registry := FormatterRegistry
+	defer func() { FormatterRegistry = registry }()
+	FormatterRegistry = []Formatter{
+		&styledFormatter{&appendFormatter{"first", "1"}, []string{".txt"}, nil, true},
+		&styledFormatter{&appendFormatter{"second", "2"}, []string{".txt"}, []string{"second.cfg"}, true},
+		&styledFormatter{&appendFormatter{"third", "3"}, []string{".rs"}, nil, false},
+	}
+
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+	files := map[string]string{
+		"a.txt":          "",
+		"b.txt":          "",
+		"c.rs":           "",
+		"d.sh":           "",
+		"sub/second.cfg": "",
+		"vendor/x.txt":   "",
+		"ignored/y.txt":  "",
+		".gitignore":     "ignored/\n",
+	}
+	for file, content := range files {
+		tCheckErr(t, os.MkdirAll(filepath.Dir(filepath.Join(tmp, file)), 0755))
+		tCheckErr(t, ioutil.WriteFile(filepath.Join(tmp, file), []byte(content), 0644))
+	}
+
+	// unreadable directories are skipped (root can read them anyway)
+	locked := filepath.Join(tmp, "locked")
+	tCheckErr(t, os.MkdirAll(filepath.Join(locked, "sub"), 0755))
+	tCheckErr(t, os.Chmod(locked, 0))
+	defer os.Chmod(locked, 0755)
+
+	survey, err := SurveyRepo(tmp, NewExcluder(tmp, []string{".git"}, []string{".gitignore"}))
+	tCheckErr(t, err)
+	if survey.FileCounts[".txt"] != 2 || survey.FileCounts[".gitignore"] != 0 {
+		t.Errorf("Vendored, ignored, and dot files shouldn't be counted: %v", survey.FileCounts)
+	}
+
+	var out bytes.Buffer
+	tCheckErr(t, survey.WriteConfig(&out))
+	config := out.String()
+	for _, expected := range []string{
+		"  .txt: second  # 2 files, style from sub/second.cfg\n",
+		"  # .rs: third  # 1 file; third isn't installed\n",
+		"exclude:\n  - vendor/\n",
+		"respect_gitignore: true\n",
+		"#   .sh (1 file)\n",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected generated config to contain %q:\n%s", expected, config)
+		}
+	}
+
+	// the generated config is usable as-is
+	cfgPath := filepath.Join(tmp, ConfigFileName)
+	tCheckErr(t, ioutil.WriteFile(cfgPath, out.Bytes(), 0644))
+	cfg, err := LoadConfig(cfgPath)
+	tCheckErr(t, err)
+	if problems := cfg.Validate(false); len(problems) > 0 {
+		t.Errorf("Unexpected problems with generated config: %v", problems)
+	}
+}
+
+func TestNestedConfigs(t *testing.T) {
+	registry := FormatterRegistry
+	defer func() { FormatterRegistry = registry }()
+	first, second := &appendFormatter{"first", "1"}, &appendFormatter{"second", "2"}
+	RegisterFormatter(first)
+	RegisterFormatter(second)
+
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+	files := map[string]string{
+		"a.txt":      "a\n",
+		"gen/x.txt":  "x\n",
+		"data/x.cfg": "x\n",
+		"sub/" + ConfigFileName: `
+formatters:
+  .txt: second
+  data/*.cfg: first
+formatter_args:
+  second: [sub]
+exclude:
+  - skip.txt
+`,
+		"sub/b.txt":      "b\n",
+		"sub/skip.txt":   "skip\n",
+		"sub/data/x.cfg": "x\n",
+		"sub/deep/" + ConfigFileName: `
+formatter_args:
+  second: [deep]
+exclude:
+  - /gen/
+`,
+		"sub/deep/c.txt":          "c\n",
+		"sub/deep/skip.txt":       "skip\n",
+		"sub/deep/gen/x.txt":      "x\n",
+		"other/" + ConfigFileName: "formatters:\n  .txt: nonexistent\n",
+		"other/d.txt":             "d\n",
+	}
+	for file, content := range files {
+		tCheckErr(t, os.MkdirAll(filepath.Dir(filepath.Join(tmp, file)), 0755))
+		tCheckErr(t, ioutil.WriteFile(filepath.Join(tmp, file), []byte(content), 0644))
+	}
+
+	ctx := StylizeContext{
+		Formatters:    map[string][]Formatter{".txt": {first}},
+		FormatterArgs: map[string][]string{"first": {"root"}},
+		RootDir:       tmp,
+		Configs:       NewConfigTree(tmp, ""),
+		InPlace:       true,
+		Parallelism:   PARALLELISM,
+	}
+	stats, err := ctx.Run()
+	tCheckErr(t, err)
+	if stats.Change != 5 || stats.Error != 2 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	expected := map[string]string{
+		"a.txt":              "a\n1root\n",
+		"gen/x.txt":          "x\n1root\n",
+		"data/x.cfg":         "x\n",
+		"sub/b.txt":          "b\n2sub\n",
+		"sub/skip.txt":       "skip\n",
+		"sub/data/x.cfg":     "x\n1root\n",
+		"sub/deep/c.txt":     "c\n2deep\n",
+		"sub/deep/skip.txt":  "skip\n",
+		"sub/deep/gen/x.txt": "x\n",
+		"other/d.txt":        "d\n",
+	}
+	for file, content := range expected {
+		actual, err := ioutil.ReadFile(filepath.Join(tmp, file))
+		tCheckErr(t, err)
+		if string(actual) != content {
+			t.Errorf("Unexpected content for %s: %q, expected %q", file, actual, content)
+		}
+	}
+
+	byExt, formatterArgs, err := ctx.EffectiveConfig(filepath.Join(tmp, "sub/deep"))
+	tCheckErr(t, err)
+	if ChainNames(byExt[".txt"])[0] != "second" || ChainNames(byExt["sub/data/*.cfg"])[0] != "first" {
+		t.Errorf("Unexpected formatters: %v", byExt)
+	}
+	if strings.Join(formatterArgs["first"], " ") != "root" || strings.Join(formatterArgs["second"], " ") != "deep" {
+		t.Errorf("Unexpected formatter args: %v", formatterArgs)
+	}
+	if _, _, err = ctx.EffectiveConfig(filepath.Join(tmp, "other")); err == nil {
+		t.Error("Expected an error for an invalid nested config")
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+	tmp, _ = filepath.EvalSymlinks(tmp)
+
+	// a config above the repo is ignored
+	repo := filepath.Join(tmp, "repo")
+	for _, dir := range []string{"a/b", "c"} {
+		tCheckErr(t, os.MkdirAll(filepath.Join(repo, dir), 0755))
+	}
+	runCmd(t, repo, "git", "init")
+	for _, file := range []string{ConfigFileName, "repo/" + ConfigFileName, "repo/a/" + ConfigFileName} {
+		tCheckErr(t, ioutil.WriteFile(filepath.Join(tmp, file), nil, 0644))
+	}
+
+	// the outermost config is used and others are nested configs
+	for _, dir := range []string{"repo/a/b", "repo/a", "repo/c", "repo"} {
+		if found := FindConfig(filepath.Join(tmp, dir)); found != filepath.Join(repo, ConfigFileName) {
+			t.Errorf("Expected to find the repo's config from %s, got '%s'", dir, found)
+		}
+	}
+	tCheckErr(t, os.Remove(filepath.Join(repo, ConfigFileName)))
+	if found := FindConfig(filepath.Join(repo, "a/b")); found != filepath.Join(repo, "a", ConfigFileName) {
+		t.Errorf("Expected to find a/%s, got '%s'", ConfigFileName, found)
+	}
+	if found := FindConfig(filepath.Join(repo, "c")); len(found) > 0 {
+		t.Errorf("Config outside of the repo shouldn't be used, got %s", found)
+	}
+
+	// outside of a git repo only the directory itself is checked
+	tCheckErr(t, os.MkdirAll(filepath.Join(tmp, "other/sub"), 0755))
+	if found := FindConfig(filepath.Join(tmp, "other/sub")); len(found) > 0 {
+		t.Errorf("Only the starting directory should be checked outside of a repo, got %s", found)
+	}
+}
+
+func TestSubdir(t *testing.T) {
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+	for _, file := range []string{"a.go", "sub/b.go", "sub/deep/c.go", "subother/d.go"} {
+		tCheckErr(t, os.MkdirAll(filepath.Dir(filepath.Join(tmp, file)), 0755))
+		tCheckErr(t, ioutil.WriteFile(filepath.Join(tmp, file), []byte("package   main\n"), 0644))
+	}
+
+	var patch bytes.Buffer
+	ctx := StylizeContext{
+		Formatters:  map[string][]Formatter{".go": {&formatters.GofmtFormatter{}}},
+		RootDir:     tmp,
+		Subdir:      "sub",
+		PatchOut:    &patch,
+		Parallelism: PARALLELISM,
+	}
+	stats, err := ctx.Run()
+	tCheckErr(t, err)
+	if stats.Total != 2 || stats.Change != 2 {
+		t.Fatalf("Only files in the subdirectory should be checked, got %+v", stats)
+	}
+	// paths are still relative to the root
+	if !strings.Contains(patch.String(), "+++ b/sub/deep/c.go") {
+		t.Fatalf("Unexpected patch:\n%s", patch.String())
+	}
+}
+
+func TestCustomFormatters(t *testing.T) {
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+	registry := FormatterRegistry
+	defer func() { FormatterRegistry = registry }()
+
+	cfgPath := path.Join(tmp, ".stylize.yml")
+	cfgContent := `---
+custom_formatters:
+  - name: upper
+    extensions: ["*.up", "UPPER*"]
+    command: tr {args} "a-z" 'A-Z'
+  - name: missing
+    extensions: [.missing]
+    command: stylize-nonexistent-formatter
+  - name: path
+    extensions: [.path]
+    command: echo {file}
+formatter_args:
+  # squeeze repeated letters
+  upper: ["-s"]
+`
+	tCheckErr(t, ioutil.WriteFile(cfgPath, []byte(cfgContent), 0644))
+	cfg, err := LoadConfig(cfgPath)
+	tCheckErr(t, err)
+	tCheckErr(t, cfg.RegisterCustomFormatters())
+
+	upper := LookupFormatter("upper")
+	if upper == nil || !upper.IsInstalled() {
+		t.Fatal("Custom formatter wasn't registered")
+	}
+	if LookupFormatter("missing").IsInstalled() {
+		t.Fatal("Formatter with a missing command shouldn't be installed")
+	}
+
+	tCheckErr(t, ioutil.WriteFile(path.Join(tmp, "a.up"), []byte("hello\n"), 0644))
+	tCheckErr(t, ioutil.WriteFile(path.Join(tmp, "UPPERCASE"), []byte("excellent\n"), 0644))
+	ctx := StylizeContext{
+		Formatters:    LoadDefaultFormatters(),
+		FormatterArgs: cfg.FormatterArgs,
+		RootDir:       tmp,
+		InPlace:       true,
+		Parallelism:   PARALLELISM,
+	}
+	if len(ctx.Formatters[".missing"]) != 0 {
+		t.Fatal("Formatters that aren't installed shouldn't be used")
+	}
+	stats, err := ctx.Run()
+	tCheckErr(t, err)
+	if stats.Change != 2 || stats.Error != 0 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	for file, expected := range map[string]string{"a.up": "HELO\n", "UPPERCASE": "EXCELENT\n"} {
+		content, err := ioutil.ReadFile(path.Join(tmp, file))
+		tCheckErr(t, err)
+		if string(content) != expected {
+			t.Errorf("Unexpected content of %s: %q", file, content)
+		}
+	}
+
+	// formatters get absolute paths, since they don't run in the root dir
+	out, err := FormatBytes(&ctx, "sub/a.path", nil)
+	tCheckErr(t, err)
+	if string(out) != path.Join(tmp, "sub/a.path")+"\n" {
+		t.Errorf("Formatter got an unexpected path: %q", out)
+	}
+
+	// FormatInPlace() replaces the target of a symlink rather than the link
+	tCheckErr(t, ioutil.WriteFile(path.Join(tmp, "target.up"), []byte("link\n"), 0640))
+	tCheckErr(t, os.Symlink("target.up", path.Join(tmp, "link.up")))
+	tCheckErr(t, upper.FormatInPlace(nil, path.Join(tmp, "link.up")))
+	if target, err := os.Readlink(path.Join(tmp, "link.up")); err != nil || target != "target.up" {
+		t.Errorf("Symlink was replaced: %q, %v", target, err)
+	}
+	fi, err := os.Stat(path.Join(tmp, "target.up"))
+	tCheckErr(t, err)
+	content, err := ioutil.ReadFile(path.Join(tmp, "target.up"))
+	tCheckErr(t, err)
+	if string(content) != "LINK\n" || fi.Mode().Perm() != 0640 {
+		t.Errorf("Unexpected content or mode of symlink target: %q, %v", content, fi.Mode())
+	}
+
+	// relative programs are run from the config's directory, whatever the
+	// working directory is
+	script := path.Join(tmp, "fmt.sh")
+	tCheckErr(t, ioutil.WriteFile(script, []byte("#!/bin/sh\nbasename \"$PWD\"\n"), 0755))
+	cfg.CustomFormatters = []CustomFormatterConfig{{Name: "script", Extensions: []string{".script"}, Command: "./fmt.sh"}}
+	tCheckErr(t, cfg.RegisterCustomFormatters())
+	wd, err := os.Getwd()
+	tCheckErr(t, err)
+	tCheckErr(t, os.Chdir(os.TempDir()))
+	defer os.Chdir(wd)
+	scriptFormatter := LookupFormatter("script")
+	if !scriptFormatter.IsInstalled() {
+		t.Fatal("Relative custom formatter should be installed")
+	}
+	var scriptOut bytes.Buffer
+	tCheckErr(t, scriptFormatter.FormatToBuffer(nil, path.Join(tmp, "a.script"), strings.NewReader(""), &scriptOut))
+	if scriptOut.String() != filepath.Base(tmp)+"\n" {
+		t.Errorf("Custom formatter ran in the wrong directory: %q", scriptOut.String())
+	}
+	tCheckErr(t, os.Chdir(wd))
+
+	// unterminated quotes are reported when registering
+	cfg.CustomFormatters = []CustomFormatterConfig{{Name: "bad", Extensions: []string{".bad"}, Command: "tr 'a-z"}}
+	if cfg.RegisterCustomFormatters() == nil {
+		t.Fatal("Expected an error for an invalid command")
+	}
+}
+
+// Plugin that uppercases content.
+type fakePlugin struct{}
+
+func (p *fakePlugin) Describe() plugin.DescribeResult {
+	return plugin.DescribeResult{Name: "fake-plugin", Extensions: []string{".fake"}, Version: "1"}
+}
+
+func (p *fakePlugin) Format(params plugin.FormatParams) (plugin.FormatResult, error) {
+	if strings.Contains(params.Content, "crash") {
+		os.Exit(3)
+	}
+	if strings.Contains(params.Content, "invalid") {
+		return plugin.FormatResult{}, errors.New("syntax error")
+	}
+	return plugin.FormatResult{
+		Content:     strings.ToUpper(params.Content),
+		Diagnostics: []plugin.Diagnostic{{Line: 1, Message: "uppercased"}},
+	}, nil
+}
+
+func TestPlugins(t *testing.T) {
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+	registry := FormatterRegistry
+	defer func() {
+		ShutdownPlugins()
+		FormatterRegistry = registry
+	}()
+	t.Setenv("STYLIZE_FAKE_PLUGIN", "1")
+
+	// plugins are discovered from a directory relative to the config file
+	tCheckErr(t, os.Mkdir(path.Join(tmp, "plugins"), 0755))
+	script := "#!/bin/sh\nexec '" + os.Args[0] + "'\n"
+	tCheckErr(t, ioutil.WriteFile(path.Join(tmp, "plugins", "fake"), []byte(script), 0755))
+	cfgPath := path.Join(tmp, ".stylize.yml")
+	tCheckErr(t, ioutil.WriteFile(cfgPath, []byte("---\nplugin_dir: plugins\n"), 0644))
+	cfg, err := LoadConfig(cfgPath)
+	tCheckErr(t, err)
+	tCheckErr(t, cfg.RegisterPlugins())
+
+	f := LookupFormatter("fake-plugin")
+	if f == nil {
+		t.Fatal("Plugin wasn't registered")
+	}
+
+	ctx := StylizeContext{
+		Formatters: map[string][]Formatter{".fake": {f}},
+		RootDir:    tmp,
+	}
+	formatted, err := FormatBytes(&ctx, "a.fake", []byte("hello\n"))
+	tCheckErr(t, err)
+	if string(formatted) != "HELLO\n" {
+		t.Fatalf("Unexpected output: %q", formatted)
+	}
+
+	if _, err = FormatBytes(&ctx, "a.fake", []byte("invalid")); err == nil || !strings.Contains(err.Error(), "syntax error") {
+		t.Fatalf("Expected a syntax error, got %v", err)
+	}
+
+	// the plugin is restarted after it crashes
+	if _, err = FormatBytes(&ctx, "a.fake", []byte("crash")); err == nil {
+		t.Fatal("Expected an error when the plugin crashes")
+	}
+	formatted, err = FormatBytes(&ctx, "a.fake", []byte("again"))
+	tCheckErr(t, err)
+	if string(formatted) != "AGAIN" {
+		t.Fatalf("Unexpected output after restart: %q", formatted)
+	}
+
+	// in-place formatting works like it does for built-in formatters
+	tCheckErr(t, ioutil.WriteFile(path.Join(tmp, "b.fake"), []byte("b\n"), 0644))
+	ctx.InPlace = true
+	ctx.Parallelism = PARALLELISM
+	stats, err := ctx.Run()
+	tCheckErr(t, err)
+	if stats.Change != 1 || stats.Error != 0 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	content, err := ioutil.ReadFile(path.Join(tmp, "b.fake"))
+	tCheckErr(t, err)
+	if string(content) != "B\n" {
+		t.Fatalf("Unexpected content: %q", content)
+	}
+
+	// diagnostics show up in the patch and reports
+	tCheckErr(t, ioutil.WriteFile(path.Join(tmp, "b.fake"), []byte("b\n"), 0644))
+	for _, format := range ReportFormats() {
+		var patch, report bytes.Buffer
+		ctx.InPlace = false
+		ctx.PatchOut = &patch
+		ctx.Reporter = LookupReporter(format)
+		ctx.ReportOut = &report
+		_, err = ctx.Run()
+		tCheckErr(t, err)
+		if !strings.Contains(patch.String(), "# b.fake:1: uppercased (fake-plugin)\n") {
+			t.Errorf("Patch is missing the diagnostic:\n%s", patch.String())
+		}
+		if !strings.Contains(report.String(), "uppercased") {
+			t.Errorf("%s report is missing the diagnostic:\n%s", format, report.String())
+		}
+	}
+}
+
+// Wraps gofmt to count how often batch operations are run.
+type countingBatchFormatter struct {
+	formatters.GofmtFormatter
+	lists int32
+}
+
+func (F *countingBatchFormatter) ListUnformatted(args []string, files []string) ([]string, error) {
+	atomic.AddInt32(&F.lists, 1)
+	return F.GofmtFormatter.ListUnformatted(args, files)
+}
+
+func TestBatchFormatter(t *testing.T) {
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+
+	for i := 0; i < 10; i++ {
+		content := "package main\n"
+		if i%2 == 0 {
+			content = "package   main\n"
+		}
+		tCheckErr(t, ioutil.WriteFile(path.Join(tmp, fmt.Sprintf("%d.go", i)), []byte(content), 0644))
+	}
+
+	F := &countingBatchFormatter{}
+	ctx := StylizeContext{
+		Formatters:  map[string][]Formatter{".go": {F}},
+		RootDir:     tmp,
+		Parallelism: 2,
+	}
+
+	var patch bytes.Buffer
+	ctx.PatchOut = &patch
+	stats, err := ctx.Run()
+	tCheckErr(t, err)
+	if stats.Total != 10 || stats.Change != 5 || stats.Error != 0 {
+		t.Fatalf("Unexpected check stats: %+v", stats)
+	}
+	if F.lists != 2 || strings.Count(patch.String(), "+++ ") != 5 {
+		t.Fatalf("Expected 2 batches and 5 patches, got %d and:\n%s", F.lists, patch.String())
+	}
+
+	ctx.PatchOut = nil
+	ctx.InPlace = true
+	stats, err = ctx.Run()
+	tCheckErr(t, err)
+	if stats.Change != 5 || F.lists != 4 {
+		t.Fatalf("Unexpected in-place stats: %+v, batches: %d", stats, F.lists)
+	}
+	content, err := ioutil.ReadFile(path.Join(tmp, "0.go"))
+	tCheckErr(t, err)
+	if string(content) != "package main\n" {
+		t.Fatalf("File wasn't formatted: %q", content)
+	}
+
+	// When a batch fails, the error is attributed to the file that caused it.
+	tCheckErr(t, ioutil.WriteFile(path.Join(tmp, "3.go"), []byte("package main\nfunc {\n"), 0644))
+	results := ctx.RunFormattersOnFiles(IterateAllFiles(tmp, NewExcluder(tmp, nil, nil)))
+	for r := range results {
+		if (r.Error != nil) != (r.FilePath == "3.go") {
+			t.Errorf("Unexpected result for %s: %v", r.FilePath, r.Error)
+		}
+	}
+}
+
+func TestInteractive(t *testing.T) {
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+
+	// three changes far enough apart to be separate hunks
+	lines := []string{"package   main"}
+	for _, v := range []string{"a", "b"} {
+		for i := 0; i < 8; i++ {
+			lines = append(lines, fmt.Sprintf("var %s%d = 1", v, i))
+		}
+		lines = append(lines, fmt.Sprintf("var  %s = 2", v))
+	}
+	file := path.Join(tmp, "a.go")
+	tCheckErr(t, ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0644))
+
+	review := func(answers string) (RunStats, string) {
+		var out bytes.Buffer
+		ctx := StylizeContext{
+			Formatters:  map[string][]Formatter{".go": {&formatters.GofmtFormatter{}}},
+			RootDir:     tmp,
+			InPlace:     true,
+			Parallelism: PARALLELISM,
+			Reviewer:    NewHunkReviewer(strings.NewReader(answers), &out),
+		}
+		stats, err := ctx.Run()
+		tCheckErr(t, err)
+		return stats, out.String()
+	}
+
+	// accept the first hunk, skip the second and quit at the third
+	stats, out := review("y\nn\n?\nq\n")
+	if stats.Change != 1 || strings.Count(out, "Apply this hunk") != 4 || !strings.Contains(out, reviewHelp) {
+		t.Fatalf("Unexpected review. Stats: %+v, output:\n%s", stats, out)
+	}
+	expected := append([]string{"package main", ""}, lines[1:]...)
+	content, err := ioutil.ReadFile(file)
+	tCheckErr(t, err)
+	if string(content) != strings.Join(expected, "\n")+"\n" {
+		t.Fatalf("Only the first hunk should have been applied, got:\n%s", content)
+	}
+
+	// skipping everything leaves the file alone
+	stats, _ = review("n\nn\n")
+	if stats.Change != 0 {
+		t.Fatalf("Expected no changes, got %+v", stats)
+	}
+
+	// running out of input quits
+	stats, _ = review("")
+	if stats.Change != 0 {
+		t.Fatalf("Expected no changes, got %+v", stats)
+	}
+
+	// accept the rest
+	stats, out = review("a\n")
+	if stats.Change != 1 || strings.Count(out, "Apply this hunk") != 1 {
+		t.Fatalf("Unexpected review. Stats: %+v, output:\n%s", stats, out)
+	}
+	if !isDirectoryFormatted(t, tmp, nil) {
+		t.Fatal("File should be formatted after accepting all hunks")
+	}
+
+	// edits made while the user is answering aren't overwritten
+	tCheckErr(t, ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0644))
+	edited := "package   main\n// edited\n"
+	answers := &editingReader{Reader: strings.NewReader("a\n"), edit: func() {
+		tCheckErr(t, ioutil.WriteFile(file, []byte(edited), 0644))
+	}}
+	var reviewOut bytes.Buffer
+	ctx := StylizeContext{
+		Formatters:  map[string][]Formatter{".go": {&formatters.GofmtFormatter{}}},
+		RootDir:     tmp,
+		InPlace:     true,
+		Parallelism: PARALLELISM,
+		Reviewer:    NewHunkReviewer(answers, &reviewOut),
+	}
+	stats, err = ctx.Run()
+	tCheckErr(t, err)
+	if stats.Error != 1 {
+		t.Fatalf("Expected an error for a file edited during review, got %+v", stats)
+	}
+	content, err = ioutil.ReadFile(file)
+	tCheckErr(t, err)
+	if string(content) != edited {
+		t.Fatalf("Edits made during review were overwritten: %q", content)
+	}
+}
+
+// Reader that calls edit() before the first read, like a user editing a file
+// before answering a prompt.
+type editingReader struct {
+	io.Reader
+	edit   func()
+	edited bool
+}
+
+func (r *editingReader) Read(p []byte) (int, error) {
+	if !r.edited {
+		r.edited = true
+		r.edit()
+	}
+	return r.Reader.Read(p)
+}
+
+// Test formatter that appends a line when run normally and uppercases content
+// when run by a worker.
+type workerTestFormatter struct {
+	appendFormatter
+	failStart                bool
+	started, closed, crashes int32
+}
+
+func (F *workerTestFormatter) StartWorker() (formatters.Worker, error) {
+	if F.failStart {
+		return nil, errors.New("can't start worker")
+	}
+	atomic.AddInt32(&F.started, 1)
+	return &testWorker{F}, nil
+}
+
+type testWorker struct {
+	F *workerTestFormatter
+}
+
+func (w *testWorker) Format(args []string, file string, content []byte) ([]byte, error) {
+	if len(args) > 0 {
+		return nil, formatters.ErrWorkerUnsupported
+	}
+	// crash the first time only
+	if bytes.Contains(content, []byte("crash")) && atomic.AddInt32(&w.F.crashes, 1) == 1 {
+		return nil, formatters.ErrWorkerCrashed
+	}
+	return bytes.ToUpper(content), nil
+}
+
+func (w *testWorker) Close() error {
+	atomic.AddInt32(&w.F.closed, 1)
+	return nil
+}
+
+func TestWorkers(t *testing.T) {
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+	for i := 0; i < 6; i++ {
+		tCheckErr(t, ioutil.WriteFile(path.Join(tmp, fmt.Sprintf("%d.txt", i)), []byte("text\n"), 0644))
+	}
+	tCheckErr(t, ioutil.WriteFile(path.Join(tmp, "crash.txt"), []byte("crash\n"), 0644))
+
+	F := &workerTestFormatter{appendFormatter: appendFormatter{"worker", "appended"}}
+	ctx := StylizeContext{
+		Formatters:  map[string][]Formatter{".txt": {F}},
+		RootDir:     tmp,
+		InPlace:     true,
+		Parallelism: 2,
+		Workers:     true,
+	}
+	stats, err := ctx.Run()
+	tCheckErr(t, err)
+	if stats.Change != 7 || stats.Error != 0 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	for file, expected := range map[string]string{"0.txt": "TEXT\n", "crash.txt": "CRASH\n"} {
+		content, err := ioutil.ReadFile(path.Join(tmp, file))
+		tCheckErr(t, err)
+		if string(content) != expected {
+			t.Errorf("Unexpected content of %s: %q", file, content)
+		}
+	}
+	// the crashed worker is replaced by a new one
+	if F.started < 2 || F.started > 3 {
+		t.Errorf("Expected 2-3 workers to be started, got %d", F.started)
+	}
+	if F.closed != F.started {
+		t.Errorf("Expected all %d workers to be closed, got %d", F.started, F.closed)
+	}
+
+	// unsupported requests and workers that can't start fall back to the
+	// formatter's regular command
+	ctx.FormatterArgs = map[string][]string{"worker": {"-x"}}
+	formatted, err := FormatBytes(&ctx, "a.txt", []byte("a\n"))
+	tCheckErr(t, err)
+	if string(formatted) != "a\nappended-x\n" {
+		t.Errorf("Unexpected output for unsupported request: %q", formatted)
+	}
+	ctx.FormatterArgs = nil
+	ctx.CloseWorkers()
+
+	F.failStart = true
+	ctx2 := StylizeContext{Formatters: ctx.Formatters, RootDir: tmp, Parallelism: 2, Workers: true}
+	formatted, err = FormatBytes(&ctx2, "a.txt", []byte("a\n"))
+	tCheckErr(t, err)
+	if string(formatted) != "a\nappended\n" {
+		t.Errorf("Unexpected output when worker can't start: %q", formatted)
+	}
+}
+
+// The prettier worker should give the same output as the prettier command,
+// including for files affected by .editorconfig or listed in .prettierignore.
+func TestPrettierWorker(t *testing.T) {
+	F := LookupFormatter("prettier")
+	if F == nil || !F.IsInstalled() {
+		t.Skip("prettier isn't installed")
+	}
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+	tCheckErr(t, ioutil.WriteFile(path.Join(tmp, ".editorconfig"), []byte("root = true\n[*]\nindent_style = tab\n"), 0644))
+	tCheckErr(t, ioutil.WriteFile(path.Join(tmp, ".prettierignore"), []byte("ignored.js\n"), 0644))
+
+	// ignore files are read from the working directory
+	wd, err := os.Getwd()
+	tCheckErr(t, err)
+	tCheckErr(t, os.Chdir(tmp))
+	defer os.Chdir(wd)
+
+	worker, err := F.(WorkerFormatter).StartWorker()
+	tCheckErr(t, err)
+	defer worker.Close()
+
+	content := []byte("function f() {\nreturn 1\n}\n")
+	for _, file := range []string{"a.js", "ignored.js"} {
+		absPath := path.Join(tmp, file)
+		var cliOut bytes.Buffer
+		tCheckErr(t, F.FormatToBuffer(nil, absPath, bytes.NewReader(content), &cliOut))
+		workerOut, err := worker.Format(nil, absPath, content)
+		tCheckErr(t, err)
+		if string(workerOut) != cliOut.String() {
+			t.Errorf("Worker and command output differ for %s:\n%q\n%q", file, workerOut, cliOut.String())
+		}
+	}
+	if formatted, _ := worker.Format(nil, path.Join(tmp, "a.js"), content); !bytes.Contains(formatted, []byte("\treturn")) {
+		t.Errorf(".editorconfig wasn't used: %q", formatted)
+	}
+}
+
+func TestInPlaceKeepsFileAttributes(t *testing.T) {
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+
+	tCheckErr(t, os.Mkdir(path.Join(tmp, "target"), 0755))
+	realFile := path.Join(tmp, "target", "real.go")
+	tCheckErr(t, ioutil.WriteFile(realFile, []byte("package   main\n"), 0750))
+	tCheckErr(t, os.Chmod(realFile, 0750))
+	tCheckErr(t, os.Symlink(path.Join("target", "real.go"), path.Join(tmp, "link.go")))
+	tCheckErr(t, ioutil.WriteFile(path.Join(tmp, "good.go"), []byte("package main\n"), 0644))
+
+	ctx := StylizeContext{
+		Formatters:  map[string][]Formatter{".go": {LookupFormatter("gofmt")}},
+		RootDir:     tmp,
+		Exclude:     []string{"target"},
+		InPlace:     true,
+		Parallelism: PARALLELISM,
+	}
+	stats, err := ctx.Run()
+	tCheckErr(t, err)
+	if stats.Change != 1 || stats.Total != 2 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	// the symlink's target was replaced and the link itself was kept
+	fi, err := os.Lstat(path.Join(tmp, "link.go"))
+	tCheckErr(t, err)
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Error("Symlink was replaced by a regular file")
+	}
+	content, err := ioutil.ReadFile(realFile)
+	tCheckErr(t, err)
+	if string(content) != "package main\n" {
+		t.Errorf("Symlink target wasn't formatted: %q", content)
+	}
+	fi, err = os.Stat(realFile)
+	tCheckErr(t, err)
+	if fi.Mode().Perm() != 0750 {
+		t.Errorf("File mode changed to %v", fi.Mode())
+	}
+
+	// no temp files are left behind
+	entries, err := ioutil.ReadDir(path.Join(tmp, "target"))
+	tCheckErr(t, err)
+	if len(entries) != 1 {
+		t.Errorf("Expected only real.go in the target directory, found %d files", len(entries))
+	}
+}
+
+func TestStaged(t *testing.T) {
+	tmp := mktmp(t)
+	dir := copyTestData(t, tmp)
+
+	runCmd(t, dir, "git", "init", "--initial-branch=main")
+	runCmd(t, dir, "git", "add", ".")
+	runCmd(t, dir, "git", "commit", "-m", "first commit")
+
+	// stage an unformatted file, then add an unstaged change on top of it
+	staged := "package main\nfunc main()\n"
+	tCheckErr(t, ioutil.WriteFile(path.Join(dir, "new.go"), []byte(staged), 0644))
+	runCmd(t, dir, "git", "add", "new.go")
+	worktree := staged + "\n// unstaged comment\n"
+	tCheckErr(t, ioutil.WriteFile(path.Join(dir, "new.go"), []byte(worktree), 0644))
+
+	ctx := StylizeContext{
+		Formatters:  map[string][]Formatter{".go": {LookupFormatter("gofmt")}},
+		RootDir:     dir,
+		Staged:      true,
+		Parallelism: PARALLELISM,
+	}
+
+	// bad.go is unformatted, but only new.go has staged changes
+	stats, err := ctx.Run()
+	tCheckErr(t, err)
+	if stats.Total != 1 || stats.Change != 1 {
+		t.Fatalf("Expected one file needing formatting, got %d / %d", stats.Change, stats.Total)
+	}
+
+	ctx.InPlace = true
+	stats, err = ctx.Run()
+	tCheckErr(t, err)
+	if stats.Change != 1 || stats.Error != 0 {
+		t.Fatal("Staged file should have been formatted")
+	}
+
+	index, err := gitIndexContent(dir, "new.go")
+	tCheckErr(t, err)
+	if string(index) != "package main\n\nfunc main()\n" {
+		t.Fatalf("Unexpected index content: %q", index)
+	}
+	content, err := ioutil.ReadFile(path.Join(dir, "new.go"))
+	tCheckErr(t, err)
+	if string(content) != "package main\n\nfunc main()\n\n// unstaged comment\n" {
+		t.Fatalf("Unexpected working tree content: %q", content)
+	}
+
+	os.RemoveAll(tmp)
+}
+
+func TestFilterChanges(t *testing.T) {
+	original := "a\nb\nc\nd\ne\n"
+	formatted := "A\nb\nc\nD\nd2\ne\n"
+
+	filtered := filterChanges(original, formatted, []formatters.LineRange{{Start: 3, End: 4}})
+	if filtered != "a\nb\nc\nD\nd2\ne\n" {
+		t.Fatalf("Unexpected filtered content: %q", filtered)
+	}
+
+	mapped := mapRanges(original, filtered, []formatters.LineRange{{Start: 4, End: 5}})
+	if len(mapped) != 1 || mapped[0] != (formatters.LineRange{Start: 4, End: 6}) {
+		t.Fatalf("Unexpected mapped ranges: %v", mapped)
+	}
+}
+
+func TestChangedLinesOnly(t *testing.T) {
+	tmp := mktmp(t)
+	writeGo := func(yValue string) {
+		content := "package main\n\nfunc a() {\nx := 1\n_ = x\n}\n\nfunc b() {\ny := " + yValue + "\n_ = y\n}\n"
+		tCheckErr(t, ioutil.WriteFile(path.Join(tmp, "main.go"), []byte(content), 0644))
+	}
+
+	writeGo("2")
+	runCmd(t, tmp, "git", "init", "--initial-branch=main")
+	runCmd(t, tmp, "git", "add", ".")
+	runCmd(t, tmp, "git", "commit", "-m", "first commit")
+	writeGo("3")
+
+	ctx := StylizeContext{
+		Formatters:       map[string][]Formatter{".go": {LookupFormatter("gofmt")}},
+		RootDir:          tmp,
+		GitDiffbase:      "main",
+		ChangedLinesOnly: true,
+		InPlace:          true,
+		Parallelism:      PARALLELISM,
+	}
+	stats, err := ctx.Run()
+	tCheckErr(t, err)
+	if stats.Change != 1 {
+		t.Fatalf("Expected one change, got %d", stats.Change)
+	}
+
+	content, err := ioutil.ReadFile(path.Join(tmp, "main.go"))
+	tCheckErr(t, err)
+	expected := "package main\n\nfunc a() {\nx := 1\n_ = x\n}\n\nfunc b() {\n\ty := 3\n\t_ = y\n}\n"
+	if string(content) != expected {
+		t.Fatalf("Unexpected content: %q", content)
+	}
+
+	// paths with spaces or quotes and files in a "b" directory are found
+	// regardless of the user's diff prefix settings
+	files := []string{"a b.go", "b/c.go", "q\"x.go"}
+	tCheckErr(t, os.Mkdir(path.Join(tmp, "b"), 0755))
+	for _, file := range files {
+		tCheckErr(t, ioutil.WriteFile(path.Join(tmp, file), []byte("package main\n"), 0644))
+	}
+	runCmd(t, tmp, "git", "add", ".")
+	runCmd(t, tmp, "git", "commit", "-m", "second commit")
+	for _, file := range files {
+		tCheckErr(t, ioutil.WriteFile(path.Join(tmp, file), []byte("package main\nfunc f()\n"), 0644))
+	}
+	for _, setting := range []string{"diff.noprefix", "diff.mnemonicPrefix"} {
+		runCmd(t, tmp, "git", "config", setting, "true")
+		changed, err := gitChangedLines(tmp, "HEAD")
+		tCheckErr(t, err)
+		for _, file := range files {
+			if len(changed[file]) != 1 || changed[file][0] != (formatters.LineRange{Start: 2, End: 2}) {
+				t.Errorf("Unexpected changed lines for %q with %s: %v", file, setting, changed)
+			}
+		}
+	}
+
+	os.RemoveAll(tmp)
+}
+
+// Returns fake results for testing reporters.
+func fakeReportResults() []FormattingResult {
+	return []FormattingResult{
+		{
+			FilePath:     "a.go",
+			FormatNeeded: true,
+			Formatters:   []string{"gofmt"},
+			Original:     []byte("package a\nfunc a()\n"),
+			Formatted:    []byte("package a\n\nfunc a()\n"),
+			Patch:        "--- a/a.go\n+++ b/a.go\n@@ -1,2 +1,3 @@\n package a\n+\n func a()\n",
+		},
+		{
+			FilePath:   "b.py",
+			Formatters: []string{"yapf"},
+			Error:      errors.New("yapf: syntax error"),
+		},
+		{
+			FilePath:   "c.go",
+			Formatters: []string{"gofmt"},
+		},
+	}
+}
+
+func TestSarifReport(t *testing.T) {
+	var out bytes.Buffer
+	tCheckErr(t, (&SarifReporter{}).WriteReport(fakeReportResults(), &out))
+
+	var report sarifLog
+	tCheckErr(t, json.Unmarshal(out.Bytes(), &report))
+
+	run := report.Runs[0]
+	if len(run.Results) != 1 {
+		t.Fatalf("Expected one result, got %d", len(run.Results))
+	}
+	result := run.Results[0]
+	if result.RuleID != "gofmt" || result.Locations[0].PhysicalLocation.ArtifactLocation.URI != "a.go" {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+	if region := result.Locations[0].PhysicalLocation.Region; region.StartLine != 2 || region.EndLine != 2 {
+		t.Fatalf("Unexpected region: %+v", region)
+	}
+
+	replacements := result.Fixes[0].ArtifactChanges[0].Replacements
+	if len(replacements) != 1 || replacements[0].InsertedContent.Text != "\n" || replacements[0].DeletedRegion.StartLine != 2 || replacements[0].DeletedRegion.EndLine != 2 {
+		t.Fatalf("Unexpected replacements: %+v", replacements)
+	}
+
+	notifications := run.Invocations[0].ToolExecutionNotifications
+	if len(notifications) != 1 || notifications[0].Message.Text != "yapf: syntax error" {
+		t.Fatalf("Unexpected notifications: %+v", notifications)
+	}
+
+	// deletions at the end of the file end at the end of the last line
+	result = sarifFileResult(FormattingResult{
+		FilePath:   "d.go",
+		Formatters: []string{"gofmt"},
+		Original:   []byte("package d\n\n\n"),
+		Formatted:  []byte("package d\n"),
+	})
+	region := result.Fixes[0].ArtifactChanges[0].Replacements[0].DeletedRegion
+	if region.StartLine != 2 || region.StartColumn != 1 || region.EndLine != 3 || region.EndColumn != 2 {
+		t.Fatalf("Unexpected deleted region: %+v", region)
+	}
+}
+
+func TestJUnitReport(t *testing.T) {
+	var out bytes.Buffer
+	tCheckErr(t, (&JUnitReporter{}).WriteReport(fakeReportResults(), &out))
+
+	var report junitTestSuites
+	tCheckErr(t, xml.Unmarshal(out.Bytes(), &report))
+
+	if report.Tests != 3 || report.Failures != 1 || report.Errors != 1 {
+		t.Fatalf("Unexpected counts: %d tests, %d failures, %d errors", report.Tests, report.Failures, report.Errors)
+	}
+	cases := report.Suites[0].TestCases
+	if cases[0].Failure == nil || !strings.Contains(cases[0].Failure.Text, "+++ b/a.go") {
+		t.Fatalf("Expected a failure with the patch for a.go: %+v", cases[0])
+	}
+	if cases[1].Error == nil || cases[1].Error.Text != "yapf: syntax error" {
+		t.Fatalf("Expected an error for b.py: %+v", cases[1])
+	}
+	if cases[2].Failure != nil || cases[2].Error != nil {
+		t.Fatalf("Expected c.go to pass: %+v", cases[2])
+	}
+}
+
+func TestCheckstyleReport(t *testing.T) {
+	var out bytes.Buffer
+	tCheckErr(t, (&CheckstyleReporter{}).WriteReport(fakeReportResults(), &out))
+
+	var report checkstyleReport
+	tCheckErr(t, xml.Unmarshal(out.Bytes(), &report))
+
+	if len(report.Files) != 3 {
+		t.Fatalf("Expected 3 files, got %d", len(report.Files))
+	}
+	errs := report.Files[0].Errors
+	if len(errs) != 1 || errs[0].Line != 2 || errs[0].Source != "stylize.gofmt" {
+		t.Fatalf("Unexpected errors for a.go: %+v", errs)
+	}
+	if errs = report.Files[1].Errors; len(errs) != 1 || errs[0].Severity != "error" {
+		t.Fatalf("Unexpected errors for b.py: %+v", errs)
+	}
+	if len(report.Files[2].Errors) != 0 {
+		t.Fatal("c.go shouldn't have any errors")
+	}
+
+	// changes close enough to share a hunk in the patch get one entry
+	out.Reset()
+	tCheckErr(t, (&CheckstyleReporter{}).WriteReport([]FormattingResult{{
+		FilePath:     "d.go",
+		FormatNeeded: true,
+		Formatters:   []string{"gofmt"},
+		Original:     []byte("a\nB\nc\nd\nE\nf\n"),
+		Formatted:    []byte("a\nb\nc\nd\ne\nf\n"),
+	}}, &out))
+	report = checkstyleReport{}
+	tCheckErr(t, xml.Unmarshal(out.Bytes(), &report))
+	if errs := report.Files[0].Errors; len(errs) != 1 || errs[0].Line != 2 || errs[0].Message != "Lines 2-5 need formatting" {
+		t.Fatalf("Expected one entry for the hunk, got %+v", errs)
+	}
+}
+
+// Waits up to a couple seconds for the condition to become true.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	for i := 0; i < 200; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+func TestWatcher(t *testing.T) {
+	tmp := mktmp(t)
+	goFile := path.Join(tmp, "main.go")
+	cfgFile := path.Join(tmp, ".stylize.yml")
+	baseFile := path.Join(tmp, "base.yml")
+	tCheckErr(t, ioutil.WriteFile(cfgFile, []byte("---\nextends: [base.yml]\n"), 0644))
+	tCheckErr(t, ioutil.WriteFile(baseFile, []byte("---\n"), 0644))
+	tCheckErr(t, os.Mkdir(path.Join(tmp, "sub"), 0755))
+
+	newCtx := func() *StylizeContext {
+		return &StylizeContext{
+			Formatters:  map[string][]Formatter{".go": {LookupFormatter("gofmt")}},
+			RootDir:     tmp,
+			ConfigFile:  cfgFile,
+			Configs:     NewConfigTree(tmp, cfgFile),
+			InPlace:     true,
+			Parallelism: PARALLELISM,
+		}
+	}
+	var reloads int32
+	watcher := Watcher{
+		PollInterval: 10 * time.Millisecond,
+		Debounce:     20 * time.Millisecond,
+		Reload: func() (*StylizeContext, error) {
+			atomic.AddInt32(&reloads, 1)
+			return newCtx(), nil
+		},
+	}
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- watcher.Run(newCtx(), stop)
+	}()
+
+	// give the watcher time to start, then write a file
+	time.Sleep(50 * time.Millisecond)
+	tCheckErr(t, ioutil.WriteFile(goFile, []byte("package main\nfunc main()\n"), 0644))
+	waitFor(t, "file to be formatted", func() bool {
+		content, _ := ioutil.ReadFile(goFile)
+		return string(content) == "package main\n\nfunc main()\n"
+	})
+
+	// files that the config extends and nested configs are watched too
+	for i, file := range []string{cfgFile, baseFile, path.Join(tmp, "sub", ".stylize.yml")} {
+		content := "---\nexclude: []\n"
+		if file == cfgFile {
+			content = "---\nextends: [base.yml]\nexclude: []\n"
+		}
+		tCheckErr(t, ioutil.WriteFile(file, []byte(content), 0644))
+		waitFor(t, "config to be reloaded after "+file+" changed", func() bool {
+			return atomic.LoadInt32(&reloads) > int32(i)
+		})
+	}
+
+	// files in new directories are checked
+	tCheckErr(t, os.Mkdir(path.Join(tmp, "new"), 0755))
+	newFile := path.Join(tmp, "new", "new.go")
+	tCheckErr(t, ioutil.WriteFile(newFile, []byte("package new\nfunc f()\n"), 0644))
+	waitFor(t, "file in new directory to be formatted", func() bool {
+		content, _ := ioutil.ReadFile(newFile)
+		return string(content) == "package new\n\nfunc f()\n"
+	})
+
+	close(stop)
+	tCheckErr(t, <-done)
+	os.RemoveAll(tmp)
+}
+
+// Encodes messages for the language server in order.
+func lspInput(messages ...string) io.Reader {
+	var in bytes.Buffer
+	for _, msg := range messages {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	return &in
+}
+
+func TestLSP(t *testing.T) {
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+	ctx := &StylizeContext{
+		Formatters: map[string][]Formatter{".go": {LookupFormatter("gofmt")}},
+		RootDir:    tmp,
+		Exclude:    []string{"excluded"},
+	}
+
+	uri := "file://" + filepath.ToSlash(path.Join(tmp, "main.go"))
+	excludedURI := "file://" + filepath.ToSlash(path.Join(tmp, "excluded", "main.go"))
+	text, _ := json.Marshal("package main\nfunc main() {\nx:=1\n_ = x\n}\n")
+	in := lspInput(
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"`+uri+`","text":`+string(text)+`}}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"`+excludedURI+`","text":`+string(text)+`}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/formatting","params":{"textDocument":{"uri":"`+uri+`"}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/rangeFormatting","params":{"textDocument":{"uri":"`+uri+`"},"range":{"start":{"line":2,"character":0},"end":{"line":4,"character":0}}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"textDocument/formatting","params":{"textDocument":{"uri":"`+excludedURI+`"}}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didSave","params":{"textDocument":{"uri":"`+uri+`"}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"unknown/method","params":{}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	)
+	var out bytes.Buffer
+	tCheckErr(t, ServeLSP(ctx, in, &out))
+
+	// Parse responses and notifications
+	type message struct {
+		ID     int             `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+		Result json.RawMessage `json:"result"`
+		Error  *lspError       `json:"error"`
+	}
+	responses := make(map[int]message)
+	var diagnostics []lspDiagnostic
+	s := &lspServer{in: bufio.NewReader(&out)}
+	for {
+		raw, err := s.readMessage()
+		if err == io.EOF {
+			break
+		}
+		tCheckErr(t, err)
+		body, _ := json.Marshal(raw)
+		var msg message
+		tCheckErr(t, json.Unmarshal(body, &msg))
+		if msg.Method == "textDocument/publishDiagnostics" {
+			var params struct {
+				Diagnostics []lspDiagnostic `json:"diagnostics"`
+			}
+			tCheckErr(t, json.Unmarshal(msg.Params, &params))
+			diagnostics = params.Diagnostics
+		} else {
+			responses[msg.ID] = msg
+		}
+	}
+
+	edits := func(id int) []lspTextEdit {
+		var edits []lspTextEdit
+		if responses[id].Error != nil {
+			t.Fatalf("Request %d failed: %s", id, responses[id].Error.Message)
+		}
+		tCheckErr(t, json.Unmarshal(responses[id].Result, &edits))
+		return edits
+	}
+
+	if e := edits(2); len(e) != 2 || e[0].NewText != "\n" || e[1].NewText != "\tx := 1\n\t_ = x\n" {
+		t.Errorf("Unexpected formatting edits: %+v", e)
+	}
+	// the blank line after the package clause is outside of the range
+	if e := edits(3); len(e) != 1 || e[0].Range.Start.Line != 2 || e[0].Range.End.Line != 4 || e[0].NewText != "\tx := 1\n\t_ = x\n" {
+		t.Errorf("Unexpected range formatting edits: %+v", e)
+	}
+	if e := edits(4); len(e) != 0 {
+		t.Errorf("Excluded file shouldn't be formatted: %+v", e)
+	}
+	if len(diagnostics) != 2 || diagnostics[0].Range.Start.Line != 1 || diagnostics[1].Range.Start.Line != 2 {
+		t.Errorf("Unexpected diagnostics: %+v", diagnostics)
+	}
+	if responses[5].Error == nil || responses[5].Error.Code != lspMethodNotFound {
+		t.Error("Unknown methods should return an error")
+	}
+	if _, ok := responses[6]; !ok {
+		t.Error("Missing response to shutdown")
+	}
+
+	// exiting without a shutdown request is an error
+	in = lspInput(
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	)
+	if err := ServeLSP(ctx, in, ioutil.Discard); err != ErrExitWithoutShutdown {
+		t.Errorf("Expected ErrExitWithoutShutdown, got %v", err)
+	}
+}
+
+func TestCollectPatch(t *testing.T) {
+	// Send fake results to a new channel.
+	results := make(chan FormattingResult)
+	go func() {
+		results <- FormattingResult{
+			FilePath:     "a",
+			Patch:        "diff1\ndiff2\n",
+			FormatNeeded: true,
+		}
+		results <- FormattingResult{
+			FilePath:     "b",
+			Patch:        "diff3\ndiff4\n",
+			FormatNeeded: true,
+		}
+		close(results)
+	}()
+
+	var patchOut bytes.Buffer
+	resultsAfterPatch := CollectPatch(results, &patchOut)
+	// consume results to run pipeline
+	for range resultsAfterPatch {
+	}
+
+	expected := "diff1\ndiff2\ndiff3\ndiff4\n"
+	if expected != patchOut.String() {
+		t.Logf("Expected: %s", expected)
+		t.Fatalf("Got: %s", patchOut.String())
+	}
+}
+
+func TestFormatterStats(t *testing.T) {
+	results := make(chan FormattingResult)
+	go func() {
+		results <- FormattingResult{FilePath: "a.go", Formatters: []string{"gofmt"}, Duration: time.Second}
+		results <- FormattingResult{
+			FilePath:     "b.go",
+			Formatters:   []string{"gofmt"},
+			FormatNeeded: true,
+			Original:     []byte("a\nb\nc\n"),
+			Formatted:    []byte("a\nB\nc\nd\n"),
+			Duration:     2 * time.Second,
+		}
+		results <- FormattingResult{FilePath: "c.py", Formatters: []string{"yapf", "black"}, Error: errors.New("failed")}
+		close(results)
+	}()
+
+	stats := LogActionsWithOptions(results, LogOptions{ShowDiff: true, Summary: true})
+	if stats.Total != 3 || stats.Change != 1 || stats.Error != 1 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	gofmtStats := stats.Formatters["gofmt"]
+	expected := FormatterStats{Checked: 2, Changed: 1, LinesAdded: 2, LinesRemoved: 1, Duration: 3 * time.Second}
+	if gofmtStats == nil || *gofmtStats != expected {
+		t.Fatalf("Unexpected gofmt stats: %+v", gofmtStats)
+	}
+	if s := stats.Formatters["yapf + black"]; s == nil || s.Checked != 1 || s.Errored != 1 {
+		t.Fatalf("Unexpected chain stats: %+v", s)
+	}
+
+	var table bytes.Buffer
+	printSummaryTable(&table, stats.Formatters)
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	if len(lines) != 3 || strings.Join(strings.Fields(lines[1]), " ") != "gofmt 2 1 0 +2 -1 3s" {
+		t.Fatalf("Unexpected table:\n%s", table.String())
+	}
+
+	// files and pipes are never colorized
+	tmp := mktmp(t)
+	defer os.RemoveAll(tmp)
+	out, err := os.Create(path.Join(tmp, "out"))
+	tCheckErr(t, err)
+	defer out.Close()
+	if useColor(out) {
+		t.Fatal("Output to a file shouldn't be colorized")
+	}
+
+	patch := createPatch("b.go", []byte("a\nb\n"), []byte("a\nB\n"))
+	if colorizePatch(patch, false) != patch {
+		t.Fatal("Patch shouldn't change without color")
+	}
+	colored := colorizePatch(patch, true)
+	if !strings.Contains(colored, colorRed+"-b"+colorReset+"\n") || !strings.Contains(colored, colorGreen+"+B"+colorReset+"\n") {
+		t.Fatalf("Unexpected colorized patch: %q", colored)
+	}
+}
+
+func tCheckErr(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mktmp(t *testing.T) string {
+	tmp, err := ioutil.TempDir("", "stylize")
+	tCheckErr(t, err)
+
+	t.Log("tmp dir: " + tmp)
+	return tmp
+}
+
+func copyTestData(t *testing.T, dir string) string {
+	cpCmd := exec.Command("cp", "-r", "testdata/", dir)
+	err := cpCmd.Run()
+	tCheckErr(t, err)
+	return path.Join(dir, "testdata")
+}
+
+func runCmd(t *testing.T, dir string, bin string, args ...string) {
+	t.Logf("cmd: %s %s", bin, strings.Join(args, " "))
+	cmd := exec.Command(bin, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Dir = dir
+	err := cmd.Run()
+	if err != nil {
+		t.Fatal(err, stderr.String())
+	}
+}
+
+func assertGoldenMatch(t *testing.T, goldenFile string, genfileContent string) {
+	goldenContent, err := ioutil.ReadFile(goldenFile)
+	tCheckErr(t, err)
+
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(goldenContent)),
+		B:        difflib.SplitLines(genfileContent),
+		FromFile: "golden",
+		ToFile:   "generated",
+		Context:  3,
+	})
+
+	if len(diff) > 0 {
+		t.Log("Diff:\n" + diff)
+		t.Fatal("Generated patch doesn't match golden")
+	}
+}
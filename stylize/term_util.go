@@ -0,0 +1,94 @@
+package stylize
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+type winsize struct {
+	Row    uint16
+	Col    uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+func isTerminal(fd *os.File) bool {
+	return terminal.IsTerminal(int(fd.Fd()))
+}
+
+func getTermWidth(scall uintptr) uint {
+	ws := &winsize{}
+	retCode, _, errno := syscall.Syscall(syscall.SYS_IOCTL,
+		uintptr(scall),
+		uintptr(syscall.TIOCGWINSZ),
+		uintptr(unsafe.Pointer(ws)))
+
+	if int(retCode) == -1 {
+		panic(errno)
+	}
+	return uint(ws.Col)
+}
+
+func padToWidth(text string, w int) string {
+	spCount := w - len(text)
+	if spCount < 0 {
+		spCount = 0
+	}
+	sp := strings.Repeat(" ", spCount)
+	return fmt.Sprintf("%s%s", text, sp)
+}
+
+const (
+	colorReset = "\x1b[0m"
+	colorBold  = "\x1b[1m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorCyan  = "\x1b[36m"
+)
+
+// Returns true if output to the given file should be colorized. It must be a
+// terminal that supports colors. See https://no-color.org.
+func useColor(fd *os.File) bool {
+	if !isTerminal(fd) || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return len(os.Getenv("NO_COLOR")) == 0
+}
+
+// Colors a patch the same way `git diff` does. Returns the patch unchanged if
+// color is false.
+func colorizePatch(patch string, color bool) string {
+	if !color {
+		return patch
+	}
+
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(patch, "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		text := strings.TrimRight(line, "\r\n")
+		var c string
+		switch {
+		case strings.HasPrefix(line, "diff "), strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "+++ "):
+			c = colorBold
+		case strings.HasPrefix(line, "@@"):
+			c = colorCyan
+		case strings.HasPrefix(line, "-"):
+			c = colorRed
+		case strings.HasPrefix(line, "+"):
+			c = colorGreen
+		}
+		if len(c) > 0 {
+			b.WriteString(c + text + colorReset + line[len(text):])
+		} else {
+			b.WriteString(line)
+		}
+	}
+	return b.String()
+}
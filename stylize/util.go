@@ -0,0 +1,110 @@
+package stylize
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/justbuchanan/stylize/formatters"
+	"github.com/pkg/errors"
+)
+
+// Returns a list of files that have changed since the given git diffbase. These
+// file paths are relative to the root of the git repo, not necessarily the
+// given rootDir.
+func gitChangedFiles(rootDir, diffbase string) ([]string, error) {
+	cmd := exec.Command("git", "--no-pager", "diff", "--name-only", diffbase)
+	cmd.Dir = rootDir
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, errors.Wrap(err, stderr.String())
+	}
+
+	// note: these paths are all relative to the git root directory
+	changedFiles := strings.Split(strings.Trim(out.String(), "\n"), "\n")
+
+	return changedFiles, nil
+}
+
+// Runs git in the given directory and returns its output.
+// @param stdin optional input to the command
+func runGit(dir string, stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdin = stdin
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrap(err, stderr.String())
+	}
+	return out.Bytes(), nil
+}
+
+// Returns the top-level directory of the git repo containing dir.
+func gitRootDir(dir string) (string, error) {
+	out, err := runGit(dir, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(string(out), "\n"), nil
+}
+
+// Returns a list of files with staged changes, excluding deleted files. These
+// file paths are relative to the root of the git repo.
+func gitStagedFiles(rootDir string) ([]string, error) {
+	out, err := runGit(rootDir, nil, "--no-pager", "diff", "--cached", "--name-only", "--diff-filter=ACMR")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.Trim(string(out), "\n"), "\n"), nil
+}
+
+// Matches hunk headers like "@@ -12,3 +14,5 @@". The line counts are optional
+// and default to 1.
+var hunkHeaderRegex = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// Returns the ranges of lines that have been added or modified since the given
+// git diffbase, keyed by file. Lines that were only deleted aren't included.
+// These file paths are relative to the root of the git repo.
+func gitChangedLines(rootDir, diffbase string) (map[string][]formatters.LineRange, error) {
+	// explicit prefixes override diff.noprefix and diff.mnemonicPrefix
+	out, err := runGit(rootDir, nil, "-c", "core.quotePath=false", "--no-pager", "diff", "--no-color", "--no-ext-diff", "--src-prefix=a/", "--dst-prefix=b/", "-U0", diffbase)
+	if err != nil {
+		return nil, err
+	}
+
+	changedLines := make(map[string][]formatters.LineRange)
+	var file string
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "+++ ") {
+			// "+++ /dev/null" for deleted files, which have no added lines
+			file, err = parsePatchPath(line[4:])
+			if err != nil {
+				file = ""
+			}
+			continue
+		}
+
+		m := hunkHeaderRegex.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		start, _ := strconv.Atoi(m[1])
+		count := 1
+		if len(m[2]) > 0 {
+			count, _ = strconv.Atoi(m[2])
+		}
+		if count > 0 {
+			changedLines[file] = append(changedLines[file], formatters.LineRange{Start: start, End: start + count - 1})
+		}
+	}
+
+	return changedLines, nil
+}
@@ -0,0 +1,316 @@
+package stylize
+
+// Watch mode keeps running and checks or formats files as they're saved.
+// Directories under the root are watched for changes with fsnotify, and files
+// are checked once they stop changing. Changes to config files, including
+// nested configs and the files they extend, reload the context.
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	DefaultPollInterval = 100 * time.Millisecond
+	DefaultDebounce     = 300 * time.Millisecond
+)
+
+type Watcher struct {
+	// How often to check whether changed files are ready to be checked.
+	PollInterval time.Duration
+	// How long a file must go without changing before it's checked. This
+	// avoids running formatters in the middle of a burst of writes.
+	Debounce time.Duration
+	// Optional function that returns a new context. It's called when a config
+	// file or one of the files it extends changes.
+	Reload func() (*StylizeContext, error)
+}
+
+// State of a running watcher
+type watchState struct {
+	ctx      *StylizeContext
+	notify   *fsnotify.Watcher
+	excluder *Excluder
+	// Absolute paths of the config files in use and the files they extend
+	configFiles map[string]bool
+	// Files that have changed but haven't been checked yet, keyed by path
+	// relative to the root, along with the time they last changed
+	pending map[string]time.Time
+	// Content written to files by the formatters, keyed by path relative to
+	// the root. Used to skip the changes made by stylize itself.
+	written map[string][]byte
+}
+
+// Returns true if the file is in ctx.Subdir, or if there's no subdir.
+// @param relPath path relative to the root
+func (ctx *StylizeContext) inSubdir(relPath string) bool {
+	if len(ctx.Subdir) == 0 {
+		return true
+	}
+	rel, err := filepath.Rel(ctx.Subdir, relPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Starts watching the context's files. Existing watches are kept, so this can
+// be called again after the context changes.
+func (s *watchState) reset(ctx *StylizeContext) {
+	s.ctx = ctx
+	s.excluder = ctx.newExcluder()
+
+	s.configFiles = make(map[string]bool)
+	if len(ctx.ConfigFile) > 0 {
+		s.configFiles[ctx.ConfigFile] = true
+		if cfg, err := LoadConfig(ctx.ConfigFile); err == nil {
+			for _, file := range cfg.Files() {
+				s.configFiles[file] = true
+			}
+		}
+	}
+	if ctx.Configs != nil {
+		for _, file := range ctx.Configs.files() {
+			s.configFiles[file] = true
+		}
+	}
+	// Config files are watched through their directories, since editors often
+	// replace files rather than writing to them.
+	for file := range s.configFiles {
+		s.addWatch(filepath.Dir(file))
+	}
+
+	// nested configs in the directories between the root and the subdir apply
+	// to the subdir too
+	dir := ctx.RootDir
+	s.addWatch(dir)
+	if len(ctx.Subdir) > 0 {
+		for _, part := range strings.Split(filepath.Clean(ctx.Subdir), string(filepath.Separator)) {
+			dir = filepath.Join(dir, part)
+			s.addWatch(dir)
+		}
+	}
+	s.watchTree(ctx.Subdir, false)
+}
+
+func (s *watchState) addWatch(absDir string) {
+	if err := s.notify.Add(absDir); err != nil {
+		log.Printf("Failed to watch %s: %v", absDir, err)
+	}
+}
+
+// Watches the non-excluded directories under relDir.
+// @param queue if true, files under relDir are queued to be checked. This is
+// used for new directories, whose files may have been created before the
+// directory was watched.
+func (s *watchState) watchTree(relDir string, queue bool) {
+	root := filepath.Join(s.ctx.RootDir, relDir)
+	filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("Failed to watch %s: %v", path, err)
+			if fi != nil && fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		relPath, _ := filepath.Rel(s.ctx.RootDir, path)
+		if relPath != "." && s.excluder.IsExcluded(relPath, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() {
+			s.addWatch(path)
+		} else if queue {
+			s.queue(relPath)
+		}
+		return nil
+	})
+}
+
+// Returns true if the file should be checked: it exists, it's in the subdir,
+// it isn't excluded, and it hasn't been opted out of formatting.
+// @param relPath path relative to the root
+func (s *watchState) wants(relPath string) bool {
+	fi, err := os.Stat(filepath.Join(s.ctx.RootDir, relPath))
+	if err != nil || !fi.Mode().IsRegular() || !s.ctx.inSubdir(relPath) || s.excluder.IsExcluded(relPath, false) {
+		return false
+	}
+	// files with invalid configs are included so that the error is reported
+	// when they're checked
+	chain, _, err := s.ctx.formattersForFile(relPath)
+	return err != nil || len(chain) > 0
+}
+
+func (s *watchState) queue(relPath string) {
+	if s.wants(relPath) {
+		s.pending[relPath] = time.Now()
+	}
+}
+
+// Returns true if the file still has the content that the formatters wrote to
+// it, in which case it doesn't need to be checked again.
+func (s *watchState) isOwnWrite(relPath string) bool {
+	content, ok := s.written[relPath]
+	if !ok {
+		return false
+	}
+	delete(s.written, relPath)
+	current, err := ioutil.ReadFile(filepath.Join(s.ctx.RootDir, relPath))
+	return err == nil && bytes.Equal(current, content)
+}
+
+// Handles a filesystem event. Returns true if a config file changed and the
+// context should be reloaded.
+func (s *watchState) handle(event fsnotify.Event) bool {
+	if s.configFiles[event.Name] || filepath.Base(event.Name) == ConfigFileName {
+		return true
+	}
+	relPath, err := filepath.Rel(s.ctx.RootDir, event.Name)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return false
+	}
+
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		// renamed files show up as a create event for the new name
+		delete(s.pending, relPath)
+		return false
+	}
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return false
+	}
+
+	for _, name := range s.ctx.IgnoreFiles {
+		if filepath.Base(relPath) == name {
+			// excludes changed, so directories may need to be watched
+			s.reset(s.ctx)
+			return false
+		}
+	}
+
+	fi, err := os.Stat(event.Name)
+	if err != nil {
+		return false
+	}
+	if fi.IsDir() {
+		if event.Has(fsnotify.Create) && !s.excluder.IsExcluded(relPath, true) {
+			s.watchTree(relPath, true)
+		}
+		return false
+	}
+	s.queue(relPath)
+	return false
+}
+
+// Runs formatters on the given files and logs the results. Content written by
+// the formatters is recorded so that the resulting changes can be skipped.
+func (s *watchState) check(files []string) RunStats {
+	fileChan := make(chan string)
+	go func() {
+		defer close(fileChan)
+		for _, file := range files {
+			fileChan <- file
+		}
+	}()
+
+	results := make(chan FormattingResult)
+	go func() {
+		defer close(results)
+		for r := range s.ctx.RunFormattersOnFiles(fileChan) {
+			if s.ctx.InPlace && r.FormatNeeded && r.Error == nil && r.Formatted != nil {
+				s.written[r.FilePath] = r.Formatted
+			}
+			results <- r
+		}
+	}()
+	return LogActionsWithOptions(results, LogOptions{InPlace: s.ctx.InPlace, ShowDiff: s.ctx.ShowDiff})
+}
+
+// Watches files under ctx.RootDir until the stop channel is closed. Files that
+// already exist when watching starts are only checked once they change.
+func (w *Watcher) Run(ctx *StylizeContext, stop <-chan struct{}) error {
+	if ctx.PatchOut != nil || ctx.Reporter != nil {
+		log.Print("Patch and report output aren't supported in watch mode")
+	}
+
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer notify.Close()
+
+	s := &watchState{
+		notify:  notify,
+		pending: make(map[string]time.Time),
+		written: make(map[string][]byte),
+	}
+	s.reset(ctx)
+	defer func() {
+		s.ctx.CloseWorkers()
+	}()
+
+	log.Printf("Watching %s", filepath.Join(ctx.RootDir, ctx.Subdir))
+
+	// time of the last config change that hasn't been reloaded yet
+	var configChanged time.Time
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case event, ok := <-notify.Events:
+			if !ok {
+				return nil
+			}
+			if s.handle(event) && w.Reload != nil {
+				configChanged = time.Now()
+			}
+			continue
+		case err, ok := <-notify.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("Error watching files: %v", err)
+			continue
+		case <-ticker.C:
+		}
+		now := time.Now()
+
+		if !configChanged.IsZero() && now.Sub(configChanged) >= w.Debounce {
+			configChanged = time.Time{}
+			newCtx, err := w.Reload()
+			if err != nil {
+				log.Printf("Failed to reload config, keeping the old one: %v", err)
+			} else {
+				log.Printf("Reloaded config")
+				s.ctx.CloseWorkers()
+				// pending files are kept and checked with the new config
+				s.reset(newCtx)
+			}
+		}
+
+		var ready []string
+		for file, changed := range s.pending {
+			if now.Sub(changed) < w.Debounce {
+				continue
+			}
+			delete(s.pending, file)
+			// files may have been deleted or excluded since they changed
+			if s.wants(file) && !s.isOwnWrite(file) {
+				ready = append(ready, file)
+			}
+		}
+		if len(ready) == 0 {
+			continue
+		}
+		sort.Strings(ready)
+		s.check(ready)
+	}
+}
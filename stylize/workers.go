@@ -0,0 +1,235 @@
+package stylize
+
+// When StylizeContext.Workers is enabled, formatters that implement
+// WorkerFormatter are run by pools of long-lived worker processes. Each pool
+// holds up to ctx.Parallelism workers, which are started as they're needed and
+// kept running until CloseWorkers() is called. Crashed workers are replaced.
+// If a worker can't be started, the formatter's regular command is used
+// instead.
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"sync"
+
+	"github.com/justbuchanan/stylize/formatters"
+	"github.com/pkg/errors"
+)
+
+type workerPool struct {
+	formatter WorkerFormatter
+	name      string
+	size      int
+
+	mutex sync.Mutex
+	cond  *sync.Cond
+	idle  []formatters.Worker
+	// number of workers that are running, including ones in use
+	running int
+	// set once a worker fails to start, after which the pool isn't used
+	failed bool
+}
+
+func newWorkerPool(F Formatter, size int) *workerPool {
+	p := &workerPool{formatter: F.(WorkerFormatter), name: F.Name(), size: size}
+	p.cond = sync.NewCond(&p.mutex)
+	return p
+}
+
+// Returns an idle worker, starting a new one if the pool isn't full. Returns
+// nil if workers can't be started.
+func (p *workerPool) get() formatters.Worker {
+	p.mutex.Lock()
+	for !p.failed && len(p.idle) == 0 && p.running >= p.size {
+		p.cond.Wait()
+	}
+	if p.failed {
+		p.mutex.Unlock()
+		return nil
+	}
+	if n := len(p.idle); n > 0 {
+		w := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mutex.Unlock()
+		return w
+	}
+	p.running++
+	p.mutex.Unlock()
+
+	w, err := p.formatter.StartWorker()
+	if err != nil {
+		p.mutex.Lock()
+		if !p.failed {
+			log.Printf("Failed to start worker for %s, running it normally instead: %v", p.name, err)
+		}
+		p.failed = true
+		p.running--
+		p.cond.Broadcast()
+		p.mutex.Unlock()
+		return nil
+	}
+	return w
+}
+
+// Returns a worker to the pool after use.
+func (p *workerPool) put(w formatters.Worker) {
+	p.mutex.Lock()
+	p.idle = append(p.idle, w)
+	p.cond.Signal()
+	p.mutex.Unlock()
+}
+
+// Removes a crashed worker from the pool. A new one is started the next time
+// one is needed.
+func (p *workerPool) discard(w formatters.Worker) {
+	w.Close()
+	p.mutex.Lock()
+	p.running--
+	p.cond.Signal()
+	p.mutex.Unlock()
+}
+
+// Stops all idle workers.
+func (p *workerPool) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, w := range p.idle {
+		if err := w.Close(); err != nil {
+			log.Printf("Error stopping worker for %s: %v", p.name, err)
+		}
+	}
+	p.running -= len(p.idle)
+	p.idle = nil
+}
+
+// Formats content with a worker from the pool. Returns false if no worker
+// could handle the request, in which case the formatter should be run normally.
+func (p *workerPool) format(args []string, file string, content []byte) ([]byte, bool, error) {
+	// retry once with a new worker if one crashes
+	for attempt := 0; attempt < 2; attempt++ {
+		w := p.get()
+		if w == nil {
+			return nil, false, nil
+		}
+
+		formatted, err := w.Format(args, file, content)
+		switch errors.Cause(err) {
+		case formatters.ErrWorkerCrashed:
+			log.Printf("Worker for %s crashed, restarting it: %v", p.name, err)
+			p.discard(w)
+			continue
+		case formatters.ErrWorkerUnsupported:
+			p.put(w)
+			return nil, false, nil
+		}
+		p.put(w)
+		return formatted, true, err
+	}
+	return nil, false, nil
+}
+
+// Wraps a formatter so that it's run by a worker pool. The wrapper also
+// forwards the optional interfaces used for caching.
+type pooledFormatter struct {
+	Formatter
+	pool *workerPool
+}
+
+func (F *pooledFormatter) FormatToBuffer(args []string, file string, in io.Reader, out io.Writer) error {
+	content, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	formatted, ok, err := F.pool.format(args, file, content)
+	if !ok {
+		return F.Formatter.FormatToBuffer(args, file, bytes.NewReader(content), out)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(formatted)
+	return err
+}
+
+func (F *pooledFormatter) FormatInPlace(args []string, absPath string) error {
+	content, err := ioutil.ReadFile(absPath)
+	if err != nil {
+		return err
+	}
+	var formatted bytes.Buffer
+	if err = F.FormatToBuffer(args, absPath, bytes.NewReader(content), &formatted); err != nil {
+		return err
+	}
+	if bytes.Equal(content, formatted.Bytes()) {
+		return nil
+	}
+	return formatters.WriteFileAtomic(absPath, formatted.Bytes())
+}
+
+func (F *pooledFormatter) Version() (string, error) {
+	vf, ok := F.Formatter.(VersionedFormatter)
+	if !ok {
+		return "", errors.Errorf("Formatter %s has no version", F.Name())
+	}
+	return vf.Version()
+}
+
+func (F *pooledFormatter) StyleConfigFiles() []string {
+	if sf, ok := F.Formatter.(StyleConfigFormatter); ok {
+		return sf.StyleConfigFiles()
+	}
+	return nil
+}
+
+// Replaces formatters in the chain that support workers with ones that use the
+// context's worker pools.
+func (ctx *StylizeContext) withWorkers(chain []Formatter) []Formatter {
+	var wrapped []Formatter
+	for i, F := range chain {
+		if _, ok := F.(WorkerFormatter); !ok {
+			continue
+		}
+		// the wrapper hides support for formatting ranges
+		if _, ok := F.(RangeFormatter); ok && ctx.ChangedLinesOnly {
+			continue
+		}
+		if wrapped == nil {
+			wrapped = append([]Formatter(nil), chain...)
+		}
+		wrapped[i] = &pooledFormatter{Formatter: F, pool: ctx.workerPool(F)}
+	}
+	if wrapped == nil {
+		return chain
+	}
+	return wrapped
+}
+
+func (ctx *StylizeContext) workerPool(F Formatter) *workerPool {
+	ctx.workerMutex.Lock()
+	defer ctx.workerMutex.Unlock()
+	if ctx.workerPools == nil {
+		ctx.workerPools = make(map[string]*workerPool)
+	}
+	pool := ctx.workerPools[F.Name()]
+	if pool == nil {
+		size := ctx.Parallelism
+		if size < 1 {
+			size = 1
+		}
+		pool = newWorkerPool(F, size)
+		ctx.workerPools[F.Name()] = pool
+	}
+	return pool
+}
+
+// Stops all worker processes started by the context. Workers are started again
+// if they're needed later.
+func (ctx *StylizeContext) CloseWorkers() {
+	ctx.workerMutex.Lock()
+	defer ctx.workerMutex.Unlock()
+	for _, pool := range ctx.workerPools {
+		pool.close()
+	}
+}